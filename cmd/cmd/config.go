@@ -0,0 +1,653 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/raphaelreyna/ez-cgi/pkg/cgi"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config describes everything an ez-cgi server serves.
+// It is either loaded from a YAML or TOML file with --config or built from the command line flags.
+type config struct {
+	// Listen lists the addresses to listen on, either TCP addresses such as 127.0.0.1:8080 or Unix domain sockets such as unix:/run/ez-cgi.sock.
+	Listen     []string         `yaml:"listen" toml:"listen"`
+	UnixSocket unixSocketConfig `yaml:"unix_socket" toml:"unix_socket"`
+	TLS        *tlsConfig       `yaml:"tls" toml:"tls"`
+	Timeouts   timeoutsConfig   `yaml:"timeouts" toml:"timeouts"`
+	// Quiet hides error messages.
+	Quiet  bool           `yaml:"quiet" toml:"quiet"`
+	Routes []routeConfig  `yaml:"routes" toml:"routes"`
+	Static []staticConfig `yaml:"static" toml:"static"`
+
+	// file is the file the config was loaded from, empty if it was built from flags.
+	file string
+	// positions maps keys to where they are in file, if known.
+	positions map[string]position
+}
+
+type tlsConfig struct {
+	Cert       string `yaml:"cert" toml:"cert"`
+	Key        string `yaml:"key" toml:"key"`
+	ClientCA   string `yaml:"client_ca" toml:"client_ca"`
+	ClientAuth string `yaml:"client_auth" toml:"client_auth"`
+}
+
+// defaultDrainTimeout is how long executables still running on shutdown are waited on by default.
+const defaultDrainTimeout = 30 * time.Second
+
+type timeoutsConfig struct {
+	Read       duration `yaml:"read" toml:"read"`
+	ReadHeader duration `yaml:"read_header" toml:"read_header"`
+	Write      duration `yaml:"write" toml:"write"`
+	Idle       duration `yaml:"idle" toml:"idle"`
+	// Drain is how long executables still running on shutdown are waited on before they're terminated.
+	// Defaults to defaultDrainTimeout.
+	Drain duration `yaml:"drain" toml:"drain"`
+}
+
+// drainTimeout returns how long executables still running on shutdown should be waited on.
+func (c *config) drainTimeout() time.Duration {
+	if c.Timeouts.Drain <= 0 {
+		return defaultDrainTimeout
+	}
+	return time.Duration(c.Timeouts.Drain)
+}
+
+// routeConfig describes an executable and the path it's served on.
+type routeConfig struct {
+	// Path is where the executable is mounted, the executable handles every request under it.
+	// Path may have named parameters, such as /users/{id}, which are passed on to the executable.
+	Path string `yaml:"path" toml:"path"`
+	Exec string `yaml:"exec" toml:"exec"`
+	// ArgsTemplate lists command line arguments built from each request, see cgi.ArgTemplate.
+	ArgsTemplate []string `yaml:"args_template" toml:"args_template"`
+	// ParamArgs passes on the values of the parameters in Path as command line arguments too.
+	ParamArgs bool `yaml:"param_args" toml:"param_args"`
+	// Methods, if not empty, lists the only request methods the executable is run for.
+	Methods []string `yaml:"methods" toml:"methods"`
+	Args    []string `yaml:"args" toml:"args"`
+	Dir     string   `yaml:"dir" toml:"dir"`
+	Stderr  string   `yaml:"stderr" toml:"stderr"`
+	// Env lists environment variables to pass on to the executable,
+	// either 'KEY=VALUE' or 'KEY' to pass on the server's value.
+	Env []string `yaml:"env" toml:"env"`
+
+	Headers      map[string]string `yaml:"headers" toml:"headers"`
+	HeaderPolicy string            `yaml:"header_policy" toml:"header_policy"`
+	HeaderAllow  []string          `yaml:"header_allow" toml:"header_allow"`
+	HeaderDeny   []string          `yaml:"header_deny" toml:"header_deny"`
+
+	// Output is how the executable's output is turned into a response, see outputModes.
+	Output       string         `yaml:"output" toml:"output"`
+	Buffered     bool           `yaml:"buffered" toml:"buffered"`
+	ExitStatus   map[string]int `yaml:"exit_status" toml:"exit_status"`
+	BufferMemory size           `yaml:"buffer_memory" toml:"buffer_memory"`
+	Trailers     bool           `yaml:"trailers" toml:"trailers"`
+
+	WebSocket *websocketConfig `yaml:"websocket" toml:"websocket"`
+
+	Timeout duration     `yaml:"timeout" toml:"timeout"`
+	Limits  limitsConfig `yaml:"limits" toml:"limits"`
+
+	FormEnv *formEnvConfig `yaml:"form_env" toml:"form_env"`
+	Uploads *uploadsConfig `yaml:"uploads" toml:"uploads"`
+
+	Auth           *authConfig      `yaml:"auth" toml:"auth"`
+	CORS           *corsConfig      `yaml:"cors" toml:"cors"`
+	RateLimit      *rateLimitConfig `yaml:"rate_limit" toml:"rate_limit"`
+	IPRules        []string         `yaml:"ip_rules" toml:"ip_rules"`
+	IPRulesFile    string           `yaml:"ip_rules_file" toml:"ip_rules_file"`
+	TrustedProxies []string         `yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+// staticConfig describes a directory of static files and the path it's served on.
+type staticConfig struct {
+	Path  string   `yaml:"path" toml:"path"`
+	Dir   string   `yaml:"dir" toml:"dir"`
+	Index []string `yaml:"index" toml:"index"`
+	// Precedence is either "static", static files are served before trying the routes, or "cgi", static files are only served when the routes respond with a 404.
+	Precedence string `yaml:"precedence" toml:"precedence"`
+}
+
+type websocketConfig struct {
+	Origins    []string `yaml:"origins" toml:"origins"`
+	MaxMessage size     `yaml:"max_message" toml:"max_message"`
+}
+
+type limitsConfig struct {
+	MaxRequestBody  size `yaml:"max_request_body" toml:"max_request_body"`
+	MaxHeaderSize   size `yaml:"max_header_size" toml:"max_header_size"`
+	MaxResponseBody size `yaml:"max_response_body" toml:"max_response_body"`
+	AbortResponse   bool `yaml:"abort_response" toml:"abort_response"`
+}
+
+type formEnvConfig struct {
+	Query        bool   `yaml:"query" toml:"query"`
+	Form         bool   `yaml:"form" toml:"form"`
+	MultiValue   string `yaml:"multi_value" toml:"multi_value"`
+	Separator    string `yaml:"separator" toml:"separator"`
+	MaxFormSize  size   `yaml:"max_form_size" toml:"max_form_size"`
+	MaxValueSize size   `yaml:"max_value_size" toml:"max_value_size"`
+	MaxParams    int    `yaml:"max_params" toml:"max_params"`
+}
+
+type uploadsConfig struct {
+	Dir           string `yaml:"dir" toml:"dir"`
+	MaxFileSize   size   `yaml:"max_file_size" toml:"max_file_size"`
+	MaxFiles      int    `yaml:"max_files" toml:"max_files"`
+	MaxFieldsSize size   `yaml:"max_fields_size" toml:"max_fields_size"`
+	Manifest      bool   `yaml:"manifest" toml:"manifest"`
+}
+
+type authConfig struct {
+	Realm    string     `yaml:"realm" toml:"realm"`
+	Htpasswd string     `yaml:"htpasswd" toml:"htpasswd"`
+	JWT      *jwtConfig `yaml:"jwt" toml:"jwt"`
+}
+
+type jwtConfig struct {
+	Key       string   `yaml:"key" toml:"key"`
+	HMACKey   string   `yaml:"hmac_key" toml:"hmac_key"`
+	JWKS      string   `yaml:"jwks" toml:"jwks"`
+	Audience  string   `yaml:"audience" toml:"audience"`
+	Issuer    string   `yaml:"issuer" toml:"issuer"`
+	Claims    []string `yaml:"claims" toml:"claims"`
+	UserClaim string   `yaml:"user_claim" toml:"user_claim"`
+}
+
+type corsConfig struct {
+	Origins     []string `yaml:"origins" toml:"origins"`
+	Methods     []string `yaml:"methods" toml:"methods"`
+	Headers     []string `yaml:"headers" toml:"headers"`
+	Expose      []string `yaml:"expose" toml:"expose"`
+	Credentials bool     `yaml:"credentials" toml:"credentials"`
+	MaxAge      duration `yaml:"max_age" toml:"max_age"`
+}
+
+type rateLimitConfig struct {
+	Rate string `yaml:"rate" toml:"rate"`
+	// Burst is how many requests each client's bucket holds, 0 means 1.
+	Burst int    `yaml:"burst" toml:"burst"`
+	By    string `yaml:"by" toml:"by"`
+}
+
+// unknownFieldRegex matches the errors yaml.v3 reports for unknown keys, which mention the Go type being decoded.
+var unknownFieldRegex = regexp.MustCompile(`field (\S+) not found in type \S+`)
+
+// outputModes maps the names of output modes to their OutputHandler.
+var outputModes = map[string]cgi.OutputHandler{
+	"":        cgi.EZOutputHandler,
+	"ez":      cgi.EZOutputHandler,
+	"replace": cgi.EZOutputHandlerReplacer,
+	"cgi":     cgi.DefaultOutputHandler,
+	"sse":     cgi.SSEOutputHandler,
+	"json":    cgi.JSONOutputHandler,
+	"ndjson":  cgi.NDJSONOutputHandler,
+}
+
+// duration is a time.Duration written as a string such as "1m30s" in config files.
+type duration time.Duration
+
+func (d *duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d *duration) UnmarshalYAML(n *yaml.Node) error {
+	return yamlError(n, d.UnmarshalText([]byte(n.Value)))
+}
+
+// size is a size in bytes written with an optional K, M or G suffix in config files.
+type size int64
+
+func (s *size) UnmarshalText(text []byte) error {
+	v, err := parseSize(string(text))
+	if err != nil {
+		return err
+	}
+	*s = size(v)
+	return nil
+}
+
+func (s *size) UnmarshalYAML(n *yaml.Node) error {
+	return yamlError(n, s.UnmarshalText([]byte(n.Value)))
+}
+
+// UnmarshalTOML accepts sizes written as either integers or strings.
+func (s *size) UnmarshalTOML(v interface{}) error {
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return fmt.Errorf("invalid size: %d", v)
+		}
+		*s = size(v)
+		return nil
+	case string:
+		return s.UnmarshalText([]byte(v))
+	}
+	return fmt.Errorf("invalid size: %v", v)
+}
+
+// yamlError turns err, found while decoding n, into an error located at n that doesn't stop decoding the rest of the file.
+func yamlError(n *yaml.Node, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: %v", n.Line, err)}}
+}
+
+// position is a location in a config file.
+type position struct {
+	line, column int
+}
+
+// configError is an error found in a config, at the key path.
+type configError struct {
+	file string
+	pos  position
+	path string
+	err  error
+}
+
+func (e *configError) Error() string {
+	msg := e.err.Error()
+	// Key paths are only meaningful to the author of a config file, not to someone using flags.
+	if e.path != "" && e.file != "" {
+		msg = e.path + ": " + msg
+	}
+	loc := e.file
+	if e.pos.line > 0 {
+		loc += fmt.Sprintf(":%d:%d", e.pos.line, e.pos.column)
+	}
+	if loc != "" {
+		msg = loc + ": " + msg
+	}
+	return msg
+}
+
+// configErrors holds every error found in a config.
+type configErrors []error
+
+func (errs configErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// errorf returns an error located at the key path in c, the path's closest known ancestor is used to locate it in the file.
+func (c *config) errorf(path string, format string, v ...interface{}) error {
+	e := &configError{file: c.file, path: path, err: fmt.Errorf(format, v...)}
+	for p := path; p != ""; p = parentKey(p) {
+		if pos, ok := c.positions[p]; ok {
+			e.pos = pos
+			break
+		}
+	}
+	return e
+}
+
+// parentKey returns the parent of the key path, e.g. "routes[1]" for "routes[1].exec" and "routes" for "routes[1]".
+func parentKey(path string) string {
+	i := strings.LastIndexAny(path, ".[")
+	if i < 0 {
+		return ""
+	}
+	return path[:i]
+}
+
+// loadConfig reads and validates the YAML or TOML config file at path, the format is picked based on the file extension.
+func loadConfig(path string) (*config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &config{file: path}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = c.decodeYAML(data)
+	case ".toml":
+		err = c.decodeTOML(data)
+	default:
+		return nil, fmt.Errorf("%s: unknown config format, expected a .yaml, .yml or .toml file", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *config) decodeYAML(data []byte) error {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("%s: %v", c.file, err)
+	}
+	c.positions = make(map[string]position)
+	if len(root.Content) > 0 {
+		recordPositions(root.Content[0], "", c.positions)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			errs := make(configErrors, len(typeErr.Errors))
+			for i, msg := range typeErr.Errors {
+				msg = unknownFieldRegex.ReplaceAllString(msg, "unknown key $1")
+				errs[i] = fmt.Errorf("%s:%s", c.file, strings.TrimPrefix(msg, "line "))
+			}
+			return errs
+		}
+		return fmt.Errorf("%s: %v", c.file, err)
+	}
+	return nil
+}
+
+// recordPositions records where each key under n is, n being at the key path.
+func recordPositions(n *yaml.Node, path string, positions map[string]position) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			p := k.Value
+			if path != "" {
+				p = path + "." + k.Value
+			}
+			positions[p] = position{line: k.Line, column: k.Column}
+			recordPositions(v, p, positions)
+		}
+	case yaml.SequenceNode:
+		for i, v := range n.Content {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			positions[p] = position{line: v.Line, column: v.Column}
+			recordPositions(v, p, positions)
+		}
+	}
+}
+
+func (c *config) decodeTOML(data []byte) error {
+	md, err := toml.Decode(string(data), c)
+	if err != nil {
+		return fmt.Errorf("%s: %v", c.file, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		errs := make(configErrors, 0, len(undecoded))
+		for _, k := range undecoded {
+			errs = append(errs, c.errorf(k.String(), "unknown key"))
+		}
+		return errs
+	}
+	return nil
+}
+
+// validate checks c for errors that can be found without building its routes, returning all of them.
+// Besides the config itself, the directories it names are checked to exist, as are the users and groups of unix sockets.
+func (c *config) validate() error {
+	var errs configErrors
+	errorf := func(path string, format string, v ...interface{}) {
+		errs = append(errs, c.errorf(path, format, v...))
+	}
+
+	if len(c.Listen) == 0 {
+		errorf("listen", "at least one address is required")
+	}
+	unixSockets := false
+	for i, addr := range c.Listen {
+		if path, ok := unixSocketPath(addr); ok {
+			if !unixSocketsSupported {
+				errorf(fmt.Sprintf("listen[%d]", i), "unix domain sockets aren't supported on windows")
+			} else if path == "" {
+				errorf(fmt.Sprintf("listen[%d]", i), "missing socket path")
+			}
+			unixSockets = true
+		} else if _, _, err := net.SplitHostPort(addr); err != nil {
+			errorf(fmt.Sprintf("listen[%d]", i), "invalid address %q: must be either HOST:PORT or unix:PATH", addr)
+		}
+	}
+	if c.UnixSocket != (unixSocketConfig{}) && !unixSockets {
+		errorf("unix_socket", "requires a unix: listen address")
+	}
+	if _, _, err := c.UnixSocket.mode(); err != nil {
+		errorf("unix_socket.mode", "%v", err)
+	}
+	if _, _, err := c.UnixSocket.ids(); err != nil {
+		errorf("unix_socket", "%v", err)
+	}
+
+	if c.TLS != nil {
+		if c.TLS.Cert == "" || c.TLS.Key == "" {
+			errorf("tls", "both cert and key are required")
+		}
+		switch c.TLS.ClientAuth {
+		case "", "required", "optional":
+		default:
+			errorf("tls.client_auth", "must be one of 'required' or 'optional'")
+		}
+		if c.TLS.ClientAuth != "" && c.TLS.ClientCA == "" {
+			errorf("tls.client_auth", "requires client_ca")
+		}
+	}
+
+	if len(c.Routes) == 0 && len(c.Static) == 0 {
+		errorf("routes", "at least one route or static directory is required")
+	}
+	patterns := make([]*cgi.Pattern, len(c.Routes))
+	for i, r := range c.Routes {
+		key := func(k string) string {
+			if k == "" {
+				return fmt.Sprintf("routes[%d]", i)
+			}
+			return fmt.Sprintf("routes[%d].%s", i, k)
+		}
+
+		p := r.Path
+		if p == "" {
+			p = "/"
+		}
+		if !strings.HasPrefix(p, "/") {
+			errorf(key("path"), "must start with '/'")
+		} else if pattern, err := cgi.ParsePattern(routePath(r)); err != nil {
+			errorf(key("path"), "%s", strings.TrimPrefix(err.Error(), "cgi: "))
+		} else {
+			for j, other := range patterns[:i] {
+				if other == nil || !pattern.Conflicts(other) {
+					continue
+				}
+				if other.String() == pattern.String() {
+					errorf(key("path"), "%s is already served by routes[%d]", p, j)
+				} else {
+					errorf(key("path"), "%s conflicts with %s, they match the same paths", p, c.Routes[j].Path)
+				}
+				break
+			}
+			patterns[i] = pattern
+		}
+		if r.Exec == "" {
+			errorf(key(""), "missing exec")
+		}
+		for j, arg := range r.ArgsTemplate {
+			t, err := cgi.ParseArgTemplate(arg)
+			if err != nil {
+				errorf(fmt.Sprintf("%s[%d]", key("args_template"), j), "%s", strings.TrimPrefix(err.Error(), "cgi: "))
+				continue
+			}
+			for _, name := range t.PathParams() {
+				if patterns[i] == nil || !containsString(patterns[i].Params(), name) {
+					errorf(fmt.Sprintf("%s[%d]", key("args_template"), j), "path %s has no parameter %q", p, name)
+				}
+			}
+		}
+		for j, m := range r.Methods {
+			if m == "" || strings.ContainsAny(m, " \t,") {
+				errorf(fmt.Sprintf("%s[%d]", key("methods"), j), "invalid method %q", m)
+			}
+		}
+		for _, e := range r.Env {
+			if strings.HasPrefix(e, "=") || e == "" {
+				errorf(key("env"), "invalid environment variable %q", e)
+			}
+		}
+		if _, err := parseHeaderPolicy(r.HeaderPolicy); r.HeaderPolicy != "" && err != nil {
+			errorf(key("header_policy"), "must be one of 'override', 'append' or 'locked'")
+		}
+		if _, ok := outputModes[r.Output]; !ok {
+			errorf(key("output"), "must be one of 'ez', 'replace', 'cgi', 'sse', 'json' or 'ndjson'")
+		}
+		for code, status := range r.ExitStatus {
+			if _, err := strconv.Atoi(code); err != nil {
+				errorf(key("exit_status."+code), "invalid exit code")
+			}
+			if status < 100 || status > 999 {
+				errorf(key("exit_status."+code), "invalid status code: %d", status)
+			}
+		}
+
+		if f := r.FormEnv; f != nil {
+			if f.MultiValue != "" {
+				if _, err := cgi.ParseMultiValuePolicy(f.MultiValue); err != nil {
+					errorf(key("form_env.multi_value"), "must be one of 'join', 'first', 'last' or 'indexed'")
+				}
+			}
+			if f.MaxParams < 0 {
+				errorf(key("form_env.max_params"), "must not be negative")
+			}
+		}
+
+		if u := r.Uploads; u != nil {
+			if u.Dir != "" {
+				if fi, err := os.Stat(c.resolve(u.Dir)); err != nil {
+					errorf(key("uploads.dir"), "%v", err)
+				} else if !fi.IsDir() {
+					errorf(key("uploads.dir"), "%s is not a directory", u.Dir)
+				}
+			}
+			if u.MaxFiles < 0 {
+				errorf(key("uploads.max_files"), "must not be negative")
+			}
+		}
+
+		if a := r.Auth; a != nil {
+			switch {
+			case a.Htpasswd != "" && a.JWT != nil:
+				errorf(key("auth"), "htpasswd can't be used along with jwt")
+			case a.Htpasswd == "" && a.JWT == nil:
+				errorf(key("auth"), "one of htpasswd or jwt is required")
+			case a.JWT != nil && a.JWT.Key == "" && a.JWT.HMACKey == "" && a.JWT.JWKS == "":
+				errorf(key("auth.jwt"), "one of key, hmac_key or jwks is required")
+			}
+		}
+		if r.CORS != nil && len(r.CORS.Origins) == 0 {
+			errorf(key("cors.origins"), "at least one origin is required")
+		}
+		if rl := r.RateLimit; rl != nil {
+			if _, err := parseRate(rl.Rate); err != nil {
+				errorf(key("rate_limit.rate"), "%v", err)
+			}
+			if rl.Burst < 0 {
+				errorf(key("rate_limit.burst"), "must not be negative")
+			}
+			if rl.By != "" {
+				if _, _, err := parseRateLimitKey(rl.By); err != nil {
+					errorf(key("rate_limit.by"), "%v", err)
+				}
+			}
+		}
+		for j, rule := range r.IPRules {
+			if _, err := cgi.ParseIPRule(rule); err != nil {
+				errorf(fmt.Sprintf("%s[%d]", key("ip_rules"), j), "%v", err)
+			}
+		}
+		for j, proxy := range r.TrustedProxies {
+			if _, err := cgi.ParseNetwork(proxy); err != nil {
+				errorf(fmt.Sprintf("%s[%d]", key("trusted_proxies"), j), "%v", err)
+			}
+		}
+	}
+
+	staticPaths := make(map[string]int)
+	for i, st := range c.Static {
+		key := func(k string) string {
+			if k == "" {
+				return fmt.Sprintf("static[%d]", i)
+			}
+			return fmt.Sprintf("static[%d].%s", i, k)
+		}
+
+		p := st.Path
+		if p == "" {
+			p = "/"
+		}
+		if !strings.HasPrefix(p, "/") {
+			errorf(key("path"), "must start with '/'")
+		} else if strings.ContainsAny(p, "{}") {
+			errorf(key("path"), "static directories can't have path parameters")
+		} else if j, ok := staticPaths[staticPath(st)]; ok {
+			errorf(key("path"), "%s is already served by static[%d]", p, j)
+		} else {
+			staticPaths[staticPath(st)] = i
+		}
+		if st.Dir == "" {
+			errorf(key(""), "missing dir")
+		} else if fi, err := os.Stat(c.resolve(st.Dir)); err != nil {
+			errorf(key("dir"), "%v", err)
+		} else if !fi.IsDir() {
+			errorf(key("dir"), "%s is not a directory", st.Dir)
+		}
+		for j, idx := range st.Index {
+			if idx == "" || strings.ContainsAny(idx, `/\`) {
+				errorf(fmt.Sprintf("%s[%d]", key("index"), j), "invalid index file name %q", idx)
+			}
+		}
+		switch st.Precedence {
+		case "", "static", "cgi":
+		default:
+			errorf(key("precedence"), "must be one of 'static' or 'cgi'")
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].(*configError).pos.line < errs[j].(*configError).pos.line
+	})
+	return errs
+}
+
+// resolve returns path relative to the directory of the config file, paths in configs built from flags are left as is.
+func (c *config) resolve(path string) string {
+	if c.file == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(c.file), path)
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a config file named name to a new temporary directory, along with an executable script.sh and a public directory.
+// The returned function removes the directory.
+func writeConfig(t *testing.T, name, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "ez-cgi-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "script.sh"), []byte("#!/bin/sh\necho \"$1\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "public"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadConfig(t *testing.T) {
+	type test struct {
+		Name    string
+		File    string
+		Content string
+		// ExpectedError is what the error must end with, empty if the config is valid.
+		ExpectedError string
+	}
+
+	tt := []test{
+		test{
+			Name: "Valid",
+			File: "ez-cgi.yaml",
+			Content: `listen: [":8080"]
+routes:
+  - path: /report
+    exec: ./script.sh
+static:
+  - path: /assets
+    dir: ./public
+`,
+		},
+		test{
+			Name: "Invalid listen address",
+			File: "ez-cgi.yaml",
+			Content: `listen: ["8080"]
+routes:
+  - exec: ./script.sh
+`,
+			ExpectedError: `ez-cgi.yaml:1:10: listen[0]: invalid address "8080": must be either HOST:PORT or unix:PATH`,
+		},
+		test{
+			Name: "YAML error position",
+			File: "ez-cgi.yaml",
+			Content: `listen: [":8080"]
+routes:
+  - path: /ok
+    exec: ./script.sh
+  - exec: ./script.sh
+    path: report
+`,
+			ExpectedError: "ez-cgi.yaml:6:5: routes[1].path: must start with '/'",
+		},
+		test{
+			Name: "TOML error",
+			File: "ez-cgi.toml",
+			Content: `listen = [":8080"]
+
+[[routes]]
+path = "/ok"
+exec = "./script.sh"
+
+[[routes]]
+exec = "./script.sh"
+  path = "report"
+`,
+			ExpectedError: "ez-cgi.toml: routes[1].path: must start with '/'",
+		},
+		test{
+			Name: "TOML nested table",
+			File: "ez-cgi.toml",
+			Content: `listen = [":8080"]
+
+[[routes]]
+exec = "./script.sh"
+
+[routes.rate_limit]
+rate = "10/s"
+burst = -1
+`,
+			ExpectedError: "ez-cgi.toml: routes[0].rate_limit.burst: must not be negative",
+		},
+		test{
+			Name: "TOML unknown key",
+			File: "ez-cgi.toml",
+			Content: `listen = [":8080"]
+
+[[routes]]
+path = "/first"
+exec = "./script.sh"
+colour = "red"
+`,
+			ExpectedError: "ez-cgi.toml: routes.colour: unknown key",
+		},
+		test{
+			Name: "Duplicate routes",
+			File: "ez-cgi.yaml",
+			Content: `listen: [":8080"]
+routes:
+  - path: /report
+    exec: ./script.sh
+  - path: /other
+    exec: ./script.sh
+  - path: /report/
+    exec: ./script.sh
+`,
+			ExpectedError: "ez-cgi.yaml:7:5: routes[2].path: /report/ is already served by routes[0]",
+		},
+		test{
+			Name: "Duplicate static paths",
+			File: "ez-cgi.yaml",
+			Content: `listen: [":8080"]
+static:
+  - path: /assets
+    dir: ./public
+  - path: /assets/
+    dir: ./public
+`,
+			ExpectedError: "ez-cgi.yaml:5:5: static[1].path: /assets/ is already served by static[0]",
+		},
+		test{
+			Name: "Duplicate static roots",
+			File: "ez-cgi.yaml",
+			Content: `listen: [":8080"]
+static:
+  - dir: ./public
+  - path: /
+    dir: ./public
+`,
+			ExpectedError: "ez-cgi.yaml:4:5: static[1].path: / is already served by static[0]",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			path, cleanup := writeConfig(t, tc.File, tc.Content)
+			defer cleanup()
+
+			c, err := loadConfig(path)
+			if err == nil {
+				var s *server
+				if s, err = newServer(c); err == nil {
+					s.close()
+				}
+			}
+			switch {
+			case tc.ExpectedError == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tc.ExpectedError != "" && err == nil:
+				t.Fatalf("expected error %q, got none", tc.ExpectedError)
+			case tc.ExpectedError != "" && !strings.HasSuffix(err.Error(), tc.ExpectedError):
+				t.Fatalf("expected error ending with %q, got %q", tc.ExpectedError, err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Work with ez-cgi config files.",
+}
+
+var configCheckCmd = &cobra.Command{
+	Use:   "check FILE",
+	Short: "Validate a config file without serving it.",
+	Long: `Validate a YAML or TOML config file without serving it.
+Every route is built, so missing executables, htpasswd files and keys are reported as well.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := loadConfig(args[0])
+		if err == nil {
+			var s *server
+			if s, err = newServer(c); err == nil {
+				s.close()
+				_, err = c.tlsConfig()
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: OK\n", args[0])
+	},
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unixSocketConfig sets the mode and owner of the Unix domain sockets ez-cgi listens on.
+type unixSocketConfig struct {
+	// Mode is the octal permissions of the sockets, such as "0660".
+	// Defaults to what the umask allows.
+	Mode  string `yaml:"mode" toml:"mode"`
+	Owner string `yaml:"owner" toml:"owner"`
+	Group string `yaml:"group" toml:"group"`
+}
+
+// unixSocketPath returns the path of the Unix domain socket addr refers to, if it's one.
+func unixSocketPath(addr string) (string, bool) {
+	if !strings.HasPrefix(addr, "unix:") {
+		return "", false
+	}
+	return strings.TrimPrefix(addr, "unix:"), true
+}
+
+// mode returns the permissions the sockets should have, and whether they were set.
+func (u *unixSocketConfig) mode() (os.FileMode, bool, error) {
+	if u.Mode == "" {
+		return 0, false, nil
+	}
+	mode, err := strconv.ParseUint(u.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, false, fmt.Errorf("invalid mode %q: must be octal permissions such as '0660'", u.Mode)
+	}
+	return os.FileMode(mode), true, nil
+}
+
+// ids returns the user and group IDs the sockets should be owned by, -1 for those that weren't set.
+// Owner and Group are either names or numeric IDs.
+func (u *unixSocketConfig) ids() (int, int, error) {
+	uid, gid := -1, -1
+	if u.Owner != "" {
+		id, err := strconv.Atoi(u.Owner)
+		if err != nil {
+			usr, err := user.Lookup(u.Owner)
+			if err != nil {
+				return 0, 0, err
+			}
+			id, _ = strconv.Atoi(usr.Uid)
+		}
+		uid = id
+	}
+	if u.Group != "" {
+		id, err := strconv.Atoi(u.Group)
+		if err != nil {
+			grp, err := user.LookupGroup(u.Group)
+			if err != nil {
+				return 0, 0, err
+			}
+			id, _ = strconv.Atoi(grp.Gid)
+		}
+		gid = id
+	}
+	return uid, gid, nil
+}
+
+// listen opens a listener for each of c's addresses, closing them all if any of them fails.
+func (c *config) listen() ([]net.Listener, error) {
+	var listeners []net.Listener
+	for i, addr := range c.Listen {
+		var l net.Listener
+		var err error
+		if path, ok := unixSocketPath(addr); ok {
+			l, err = c.listenUnix(c.resolve(path))
+		} else {
+			l, err = net.Listen("tcp", addr)
+		}
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, c.errorf(fmt.Sprintf("listen[%d]", i), "%v", err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// removeStaleSocket removes the socket at path if nothing is listening on it anymore.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s already exists and isn't a socket", path)
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is already in use", path)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,51 @@
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// unixSocketsSupported reports whether unix: listen addresses can be used on this platform.
+const unixSocketsSupported = true
+
+// listenUnix opens a listener on the Unix domain socket at path, replacing the socket left behind by a previous run if there is one.
+// The socket file is removed once the listener is closed.
+func (c *config) listenUnix(path string) (net.Listener, error) {
+	mode, setMode, err := c.UnixSocket.mode()
+	if err != nil {
+		return nil, err
+	}
+	uid, gid, err := c.UnixSocket.ids()
+	if err != nil {
+		return nil, err
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	// The socket is created inaccessible and only opened up once its mode and owner are set.
+	umask := syscall.Umask(0777)
+	l, err := net.Listen("unix", path)
+	syscall.Umask(umask)
+	if err != nil {
+		return nil, err
+	}
+	if !setMode {
+		mode = 0777 &^ os.FileMode(umask)
+	}
+	if uid != -1 || gid != -1 {
+		if err = os.Chown(path, uid, gid); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	if err = os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,13 @@
+package cmd
+
+import (
+	"errors"
+	"net"
+)
+
+// unixSocketsSupported reports whether unix: listen addresses can be used on this platform.
+const unixSocketsSupported = false
+
+func (c *config) listenUnix(path string) (net.Listener, error) {
+	return nil, errors.New("unix domain sockets aren't supported on windows")
+}
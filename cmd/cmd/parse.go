@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/raphaelreyna/ez-cgi/pkg/cgi"
+	"strconv"
+	"strings"
+)
+
+// parseSize parses a size in bytes with an optional K, M or G suffix (powers of 1024).
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	mult := int64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'k', 'K':
+			mult = 1 << 10
+		case 'm', 'M':
+			mult = 1 << 20
+		case 'g', 'G':
+			mult = 1 << 30
+		}
+		if mult != 1 {
+			s = s[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return n * mult, nil
+}
+
+// parseExitStatus parses exit code to HTTP status code mappings in the form 'CODE=STATUS'.
+func parseExitStatus(mappings []string) (map[int]int, error) {
+	m := make(map[int]int, len(mappings))
+	for _, mapping := range mappings {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid exit status mapping: %s", mapping)
+		}
+		code, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid exit code: %s", mapping)
+		}
+		status, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || status < 100 || status > 999 {
+			return nil, fmt.Errorf("invalid status code: %s", mapping)
+		}
+		m[code] = status
+	}
+	return m, nil
+}
+
+// parseHeaderPolicy parses the name of a header policy.
+func parseHeaderPolicy(name string) (cgi.HeaderPolicy, error) {
+	switch name {
+	case "override":
+		return cgi.HeaderOverride, nil
+	case "append":
+		return cgi.HeaderAppend, nil
+	case "locked":
+		return cgi.HeaderLocked, nil
+	}
+	return 0, fmt.Errorf("invalid header policy: %q", name)
+}
+
+// parseRate parses a rate in the form 'N/s', 'N/m' or 'N/h' into requests per second.
+func parseRate(s string) (float64, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), "/", 2)
+	if len(parts) < 2 {
+		return 0, fmt.Errorf("invalid rate: %q: expected 'N/s', 'N/m' or 'N/h'", s)
+	}
+	n, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate: %q", s)
+	}
+	switch parts[1] {
+	case "s":
+		return n, nil
+	case "m":
+		return n / 60, nil
+	case "h":
+		return n / 3600, nil
+	}
+	return 0, fmt.Errorf("invalid rate: %q: expected 'N/s', 'N/m' or 'N/h'", s)
+}
+
+// parseRateLimitKey parses what to rate limit clients by: 'ip', 'user' or 'header:NAME'.
+func parseRateLimitKey(s string) (cgi.RateLimitKey, string, error) {
+	switch {
+	case s == "ip":
+		return cgi.RateLimitByIP, "", nil
+	case s == "user":
+		return cgi.RateLimitByUser, "", nil
+	case strings.HasPrefix(s, "header:") && len(s) > len("header:"):
+		return cgi.RateLimitByHeader, s[len("header:"):], nil
+	}
+	return 0, "", fmt.Errorf("invalid rate limit key: %q: expected 'ip', 'user' or 'header:NAME'", s)
+}
+
+// parseRoute parses a route in the form 'PATH=EXECUTABLE[;methods=METHOD,...][;output=MODE]'.
+// Anything not set by the route is taken from template.
+func parseRoute(s string, template routeConfig) (routeConfig, error) {
+	parts := strings.Split(s, ";")
+	target := strings.SplitN(parts[0], "=", 2)
+	if len(target) < 2 || target[0] == "" || target[1] == "" {
+		return routeConfig{}, fmt.Errorf("invalid route: %q: expected 'PATH=EXECUTABLE'", s)
+	}
+
+	rc := template
+	rc.Path = strings.TrimSpace(target[0])
+	rc.Exec = strings.TrimSpace(target[1])
+	rc.Args, rc.ArgsTemplate = nil, nil
+	for _, opt := range parts[1:] {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) < 2 {
+			return routeConfig{}, fmt.Errorf("invalid route option: %q: expected 'KEY=VALUE'", opt)
+		}
+		switch k, v := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]); k {
+		case "methods":
+			rc.Methods = strings.Split(v, ",")
+		case "output":
+			rc.Output = v
+		default:
+			return routeConfig{}, fmt.Errorf("invalid route option: %q: must be one of 'methods' or 'output'", k)
+		}
+	}
+	return rc, nil
+}
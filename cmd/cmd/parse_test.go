@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRoute(t *testing.T) {
+	type test struct {
+		Name     string
+		Route    string
+		Expected routeConfig
+		// ExpectedError is what the error must start with, empty if the route is valid.
+		ExpectedError string
+	}
+
+	template := routeConfig{
+		Exec:    "./default.sh",
+		Args:    []string{"--verbose"},
+		Methods: []string{"GET"},
+		Output:  "cgi",
+		Dir:     "./scripts",
+	}
+
+	tt := []test{
+		test{
+			Name:  "Path and executable",
+			Route: "/report=./report.sh",
+			Expected: routeConfig{
+				Path:    "/report",
+				Exec:    "./report.sh",
+				Methods: []string{"GET"},
+				Output:  "cgi",
+				Dir:     "./scripts",
+			},
+		},
+		test{
+			Name:  "Options",
+			Route: " /report = ./report.sh ; methods=GET,POST ; output=replace",
+			Expected: routeConfig{
+				Path:    "/report",
+				Exec:    "./report.sh",
+				Methods: []string{"GET", "POST"},
+				Output:  "replace",
+				Dir:     "./scripts",
+			},
+		},
+		test{
+			Name:          "Missing executable",
+			Route:         "/report=",
+			ExpectedError: "invalid route:",
+		},
+		test{
+			Name:          "Missing path",
+			Route:         "./report.sh",
+			ExpectedError: "invalid route:",
+		},
+		test{
+			Name:          "Option without value",
+			Route:         "/report=./report.sh;methods",
+			ExpectedError: "invalid route option:",
+		},
+		test{
+			Name:          "Unknown option",
+			Route:         "/report=./report.sh;dir=/tmp",
+			ExpectedError: "invalid route option:",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			rc, err := parseRoute(tc.Route, template)
+			if tc.ExpectedError != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tc.ExpectedError) {
+					t.Fatalf("expected error starting with %q, got %v", tc.ExpectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(rc, tc.Expected) {
+				t.Fatalf("wrong route - expected: %+v\treceived: %+v", tc.Expected, rc)
+			}
+		})
+	}
+}
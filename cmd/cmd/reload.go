@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// liveServer serves requests with its current server, which is swapped for a new one whenever the config is reloaded.
+// Requests already being served by the previous server are left to finish on it.
+type liveServer struct {
+	// load loads the config, either from the config file or the command line flags.
+	load func() (*config, error)
+
+	current atomic.Value // *server
+	// config is the config of the current server, only touched by reload.
+	config *config
+
+	// mu guards servers, the current server along with the retired ones that may still be serving requests.
+	mu      sync.Mutex
+	servers []*server
+}
+
+func (l *liveServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for {
+		s := l.current.Load().(*server)
+		if s.acquire() {
+			defer s.release()
+			s.ServeHTTP(w, r)
+			return
+		}
+		// s was retired in between loading and acquiring it, a new server has already been swapped in.
+	}
+}
+
+// reload loads the config and swaps in a server for it.
+// If the config is invalid the error is returned and the current server is kept.
+// Listen addresses, unix socket settings and server timeouts are only read the first time, changing them requires a restart.
+func (l *liveServer) reload() (err error) {
+	// A bug building the new server mustn't take down the current one along with it.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("error building server: %v", r)
+		}
+	}()
+
+	c, err := l.load()
+	if err != nil {
+		return err
+	}
+	if prev := l.config; prev != nil {
+		if (c.TLS == nil) != (prev.TLS == nil) {
+			return errors.New("TLS can't be turned on or off without a restart")
+		}
+		// The drain timeout is only used on shutdown so it can change freely.
+		timeouts := prev.Timeouts
+		timeouts.Drain = c.Timeouts.Drain
+		if !reflect.DeepEqual(c.Listen, prev.Listen) || c.UnixSocket != prev.UnixSocket || c.Timeouts != timeouts {
+			log.Println("changes to listen addresses, unix sockets and server timeouts require a restart, keeping the previous ones")
+			c.Listen, c.UnixSocket, c.Timeouts = prev.Listen, prev.UnixSocket, timeouts
+		}
+	}
+
+	s, err := newServer(c)
+	if err != nil {
+		return err
+	}
+	if s.tls, err = c.tlsConfig(); err != nil {
+		s.close()
+		return err
+	}
+	if s.tls != nil {
+		s.tls.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	prev, _ := l.current.Load().(*server)
+	l.current.Store(s)
+	l.config = c
+	if prev != nil {
+		prev.retire()
+	}
+
+	l.mu.Lock()
+	servers := []*server{s}
+	for _, s := range l.servers {
+		if !s.done() {
+			servers = append(servers, s)
+		}
+	}
+	l.servers = servers
+	l.mu.Unlock()
+	return nil
+}
+
+// wait waits for the executions in flight on every server, current or retired, to finish or for ctx to be done.
+func (l *liveServer) wait(ctx context.Context) error {
+	l.mu.Lock()
+	servers := l.servers
+	l.mu.Unlock()
+	for _, s := range servers {
+		for _, h := range s.handlers {
+			if err := h.Wait(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// signal sends sig to the executables in flight on every server, current or retired, returning how many were signaled.
+func (l *liveServer) signal(sig os.Signal) int {
+	l.mu.Lock()
+	servers := l.servers
+	l.mu.Unlock()
+	n := 0
+	for _, s := range servers {
+		for _, h := range s.handlers {
+			n += h.Signal(sig)
+		}
+	}
+	return n
+}
+
+// tlsConfig returns a TLS config that always uses the current server's certificates and client authentication settings,
+// nil if the current server doesn't use TLS.
+func (l *liveServer) tlsConfig() *tls.Config {
+	if l.current.Load().(*server).tls == nil {
+		return nil
+	}
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return l.current.Load().(*server).tls, nil
+		},
+		// GetCertificate is never called since GetConfigForClient takes precedence,
+		// but http.Server.ServeTLS requires a way to get certificates on the config it's given.
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &l.current.Load().(*server).tls.Certificates[0], nil
+		},
+	}
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	path, cleanup := writeConfig(t, "ez-cgi.yaml", `listen: [":8080"]
+routes:
+  - path: /first
+    exec: ./script.sh
+    args: [FIRST]
+`)
+	defer cleanup()
+
+	l := &liveServer{load: func() (*config, error) {
+		return loadConfig(path)
+	}}
+	if err := l.reload(); err != nil {
+		t.Fatalf("error while loading config: %s", err)
+	}
+
+	get := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code, w.Body.String()
+	}
+	if code, body := get("/first"); code != http.StatusOK || body != "FIRST\n" {
+		t.Fatalf("wrong response before reload - expected: 200 %q\treceived: %d %q", "FIRST\n", code, body)
+	}
+
+	first := l.current.Load().(*server)
+	// Hold on to the first server as if it were still serving a request.
+	if !first.acquire() {
+		t.Fatal("couldn't acquire the current server")
+	}
+
+	err := ioutil.WriteFile(path, []byte(`listen: [":8080"]
+routes:
+  - path: /second
+    exec: ./script.sh
+    args: [SECOND]
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.reload(); err != nil {
+		t.Fatalf("error while reloading config: %s", err)
+	}
+	if code, body := get("/second"); code != http.StatusOK || body != "SECOND\n" {
+		t.Fatalf("wrong response after reload - expected: 200 %q\treceived: %d %q", "SECOND\n", code, body)
+	}
+	if code, _ := get("/first"); code != http.StatusNotFound {
+		t.Fatalf("wrong status for a removed route - expected: %d\treceived: %d", http.StatusNotFound, code)
+	}
+
+	// The first server is retired but only done once its last request is, it's kept track of until then so shutdown waits on it.
+	if first.acquire() {
+		t.Fatal("retired server acquired")
+	}
+	if first.done() {
+		t.Fatal("retired server done while still serving a request")
+	}
+	if !l.tracks(first) {
+		t.Fatal("retired server no longer tracked while still serving a request")
+	}
+	first.release()
+	if !first.done() {
+		t.Fatal("retired server not done after its last request")
+	}
+
+	// An invalid config is rejected and the current one is kept.
+	second := l.current.Load().(*server)
+	err = ioutil.WriteFile(path, []byte(`listen: [":8080"]
+static:
+  - path: /assets
+    dir: ./public
+  - path: /assets/
+    dir: ./public
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.reload(); err == nil {
+		t.Fatal("invalid config reloaded")
+	}
+	if l.current.Load().(*server) != second {
+		t.Fatal("current server replaced by an invalid config")
+	}
+	if code, body := get("/second"); code != http.StatusOK || body != "SECOND\n" {
+		t.Fatalf("wrong response after a failed reload - expected: 200 %q\treceived: %d %q", "SECOND\n", code, body)
+	}
+
+	// Servers that are done are forgotten on the next reload.
+	err = ioutil.WriteFile(path, []byte(`listen: [":8080"]
+routes:
+  - path: /second
+    exec: ./script.sh
+    args: [SECOND]
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.reload(); err != nil {
+		t.Fatalf("error while reloading config: %s", err)
+	}
+	if l.tracks(first) {
+		t.Fatal("done server still tracked after reload")
+	}
+	if !second.done() {
+		t.Fatal("idle server not done after being retired")
+	}
+}
+
+// tracks reports whether s is one of the servers l keeps track of.
+func (l *liveServer) tracks(s *server) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, t := range l.servers {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
@@ -1,16 +1,20 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/raphaelreyna/ez-cgi/pkg/cgi"
 	"github.com/spf13/cobra"
-	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 var version string
@@ -19,35 +23,114 @@ var versionFlag bool
 var year string
 
 var (
-	noError bool
-	port    string
+	noError     bool
+	port        string
+	listenAddrs []string
+	socketMode  string
+	socketOwner string
+	socketGroup string
 
 	executable   string
 	dir          string
 	shell        string
 	shellCommand bool
 
-	certFile string
-	keyFile  string
-
-	rawHeaders []string
-	replace    bool
-	conformCGI bool
+	certFile      string
+	keyFile       string
+	tlsClientCA   string
+	tlsClientAuth string
+
+	rawHeaders   []string
+	headerPolicy string
+	headerAllow  []string
+	headerDeny   []string
+	replace      bool
+	conformCGI   bool
+	sse          bool
+	jsonOutput   bool
+	ndjson       bool
+
+	buffered     bool
+	exitStatus   []string
+	bufferMemory string
+
+	websocket    bool
+	wsOrigins    []string
+	wsMaxMessage int64
 
 	envVars []string
 
+	maxRequestBody  string
+	maxHeaderSize   string
+	maxResponseBody string
+	abortResponse   bool
+
+	trailers bool
+
+	timeout      time.Duration
+	drainTimeout time.Duration
+
+	htpasswd  string
+	authRealm string
+
+	jwtKey       string
+	jwtHMACKey   string
+	jwtJWKS      string
+	jwtAudience  string
+	jwtIssuer    string
+	jwtClaims    []string
+	jwtUserClaim string
+
+	queryEnv       bool
+	formEnv        bool
+	formMultiValue string
+	formSeparator  string
+	formMaxSize    string
+
+	uploads           bool
+	uploadDir         string
+	uploadMaxFileSize string
+	uploadMaxFiles    int
+	uploadManifest    bool
+
+	corsOrigins     []string
+	corsMethods     []string
+	corsHeaders     []string
+	corsExpose      []string
+	corsCredentials bool
+	corsMaxAge      time.Duration
+
+	rateLimit   string
+	rateBurst   int
+	rateLimitBy string
+
+	ipRules        []string
+	ipRulesFile    string
+	trustedProxies []string
+
 	stderr string
+
+	configFile string
+
+	routes       []string
+	paramArgs    bool
+	argsTemplate []string
+
+	statics          []string
+	staticIndex      []string
+	staticPrecedence string
 )
 
 var RootCmd = &cobra.Command{
-	Use:     "ez-cgi [flags]... executable [args]...",
+	Use:     "ez-cgi [flags]... [executable [args]...]",
 	Version: fmt.Sprintf(": %s\nyear: %s\nauthor: %s\n", version, year, "Raphael Reyna"),
 	Short:   "A friendly and easy to use (almost-)CGI HTTP server.",
 	Long: `Start a (almost-)CGI HTTP server.
 By default, ez-cgi sends the HTTP client the header 'Content-Type: text/plain'.
 If the --replace,-r flag is set and the executable doesn't set any headers, a default header will be set (Content-Type: text/plain).
 `,
-	Run: run,
+	Args: cobra.ArbitraryArgs,
+	Run:  run,
 }
 
 func SetFlags() {
@@ -56,12 +139,32 @@ Version for ez-cgi.`,
 	)
 
 	RootCmd.Flags().StringVarP(&port, "port", "p", "8080", `
-Port to bind to.`)
+Port to bind to on every interface, ignored when --listen is given.`)
+
+	RootCmd.Flags().StringArrayVar(&listenAddrs, "listen", nil, `
+Address to listen on, either HOST:PORT or unix:PATH for a Unix domain socket,
+e.g. '127.0.0.1:8080', '[::1]:8080' or 'unix:/run/ez-cgi.sock'.
+May be repeated to listen on several addresses at once.`)
+
+	RootCmd.Flags().StringVar(&socketMode, "socket-mode", "", `
+Octal permissions of the Unix domain sockets, e.g. 0660.`)
+
+	RootCmd.Flags().StringVar(&socketOwner, "socket-owner", "", `
+User that owns the Unix domain sockets, either a name or a numeric ID.`)
+
+	RootCmd.Flags().StringVar(&socketGroup, "socket-group", "", `
+Group that owns the Unix domain sockets, either a name or a numeric ID.`)
 
 	RootCmd.Flags().BoolVarP(&noError, "quiet", "q", false, `
 Don't show error messages.`,
 	)
 
+	RootCmd.Flags().StringVar(&configFile, "config", "", `
+YAML (.yaml, .yml) or TOML (.toml) file describing what to serve.
+Can't be used along with an executable or any other flag.
+See also: ez-cgi config check.`,
+	)
+
 	RootCmd.Flags().StringVar(&certFile, "tls-cert", "", `
 Certificate file to use for HTTPS.
 Key file must also be provided using the --tls-key flag.`,
@@ -70,11 +173,203 @@ Key file must also be provided using the --tls-key flag.`,
 Key file to use for HTTPS.
 Cert file must also be provided using the --tls-cert flag.`,
 	)
+	RootCmd.Flags().StringVar(&tlsClientCA, "tls-client-ca", "", `
+File of PEM encoded CA certificates used to verify client certificates.
+The client certificate is passed to the executable in the SSL_CLIENT_* environment variables.
+Requires the --tls-cert and --tls-key flags.`,
+	)
+	RootCmd.Flags().StringVar(&tlsClientAuth, "tls-client-auth", "required", `
+Whether clients must present a certificate, one of 'required' or 'optional'.
+See also: --tls-client-ca.`,
+	)
+
+	RootCmd.Flags().StringVar(&htpasswd, "htpasswd", "", `
+Require HTTP Basic authentication against the users in an htpasswd file.
+Supports bcrypt, SHA and apr1 hashes, the file is reloaded when it changes.
+The authenticated user is passed to the executable in REMOTE_USER.`,
+	)
+	RootCmd.Flags().StringVar(&authRealm, "auth-realm", "ez-cgi", `
+Realm sent to clients when asking them to authenticate.
+See also: --htpasswd, --jwt-key, --jwt-hmac-key, --jwt-jwks.`,
+	)
+	RootCmd.Flags().StringArrayVar(&ipRules, "ip-rule", nil, `
+Rule allowing or denying clients by IP address, in the form 'allow NETWORK' or 'deny NETWORK'.
+NETWORK is an IP address, a CIDR such as 10.8.0.0/16, or 'all'.
+Rules are evaluated in order, the first one matching the client decides whether it's allowed.
+Clients matching no rule are denied if there are any allow rules, and allowed otherwise.`,
+	)
+	RootCmd.Flags().StringVar(&ipRulesFile, "ip-rules", "", `
+File of IP rules, one per line, evaluated after the --ip-rule rules.
+The file is reloaded when it changes.
+See also: --ip-rule.`,
+	)
+	RootCmd.Flags().StringArrayVar(&trustedProxies, "trusted-proxy", nil, `
+IP address or CIDR of a reverse proxy whose X-Forwarded-For header is trusted to tell the client's address.
+See also: --ip-rule.`,
+	)
+
+	RootCmd.Flags().BoolVar(&queryEnv, "query-env", false, `
+Pass on each query string parameter to the executable in a QUERY_<NAME> environment variable.`,
+	)
+	RootCmd.Flags().BoolVar(&formEnv, "form-env", false, `
+Pass on each parameter of application/x-www-form-urlencoded request bodies to the executable in a FORM_<NAME> environment variable.
+The request body is still written to the executable's stdin.`,
+	)
+	RootCmd.Flags().StringVar(&formMultiValue, "form-multi-value", "join", `
+How parameters given more than once are passed on, one of 'join', 'first', 'last' or 'indexed'.
+See also: --query-env, --form-env.`,
+	)
+	RootCmd.Flags().StringVar(&formSeparator, "form-separator", ",", `
+What the values of parameters given more than once are joined with.
+See also: --form-multi-value.`,
+	)
+	RootCmd.Flags().StringVar(&formMaxSize, "form-max-size", "64K", `
+Form bodies larger than this are rejected with a 413.
+See also: --form-env.`,
+	)
+
+	RootCmd.Flags().BoolVar(&uploads, "uploads", false, `
+Parse multipart/form-data request bodies and spool their files to a temporary directory, removed once the executable exits.
+Files are described in UPLOAD_* environment variables and other fields are passed on in FORM_<NAME> ones.`,
+	)
+	RootCmd.Flags().StringVar(&uploadDir, "upload-dir", "", `
+Where the temporary directories for uploaded files are created, defaults to the system's temporary directory.
+See also: --uploads.`,
+	)
+	RootCmd.Flags().StringVar(&uploadMaxFileSize, "upload-max-file-size", "0", `
+Uploaded files larger than this are rejected with a 413, 0 means no limit.
+See also: --uploads.`,
+	)
+	RootCmd.Flags().IntVar(&uploadMaxFiles, "upload-max-files", 0, `
+Requests uploading more files than this are rejected with a 400, 0 means no limit.
+See also: --uploads.`,
+	)
+	RootCmd.Flags().BoolVar(&uploadManifest, "upload-manifest", false, `
+Also describe the uploaded files and form fields in a JSON file whose path is in UPLOAD_MANIFEST.
+See also: --uploads.`,
+	)
+
+	RootCmd.Flags().StringArrayVar(&corsOrigins, "cors-origin", nil, `
+Origin allowed to make cross-origin requests, enables CORS.
+May contain a single '*' wildcard, e.g. 'https://*.example.com', '*' allows any origin.
+Preflight requests are answered without running the executable.`,
+	)
+	RootCmd.Flags().StringArrayVar(&corsMethods, "cors-method", nil, `
+Method allowed in cross-origin requests, defaults to GET, HEAD and POST.
+See also: --cors-origin.`,
+	)
+	RootCmd.Flags().StringArrayVar(&corsHeaders, "cors-header", nil, `
+Request header allowed in cross-origin requests, '*' allows any header.
+See also: --cors-origin.`,
+	)
+	RootCmd.Flags().StringArrayVar(&corsExpose, "cors-expose", nil, `
+Response header cross-origin requests are allowed to read.
+See also: --cors-origin.`,
+	)
+	RootCmd.Flags().BoolVar(&corsCredentials, "cors-credentials", false, `
+Allow cross-origin requests to include cookies and HTTP authentication.
+See also: --cors-origin.`,
+	)
+	RootCmd.Flags().DurationVar(&corsMaxAge, "cors-max-age", 0, `
+How long browsers may cache the answer to a preflight request, e.g. 10m.
+See also: --cors-origin.`,
+	)
+
+	RootCmd.Flags().StringVar(&rateLimit, "rate-limit", "", `
+Limit how often each client can have the executable run, in the form 'N/s', 'N/m' or 'N/h'.
+Limited requests get a 429 with a Retry-After header.`,
+	)
+	RootCmd.Flags().IntVar(&rateBurst, "rate-burst", 1, `
+How many requests a client can make in a row before being rate limited.
+See also: --rate-limit.`,
+	)
+	RootCmd.Flags().StringVar(&rateLimitBy, "rate-limit-by", "ip", `
+What clients are told apart by when rate limiting, one of 'ip', 'user' or 'header:NAME'.
+Clients without an authenticated user or the header are told apart by IP address.
+See also: --rate-limit.`,
+	)
+
+	RootCmd.Flags().StringVar(&jwtKey, "jwt-key", "", `
+Require a JWT in the 'Authorization: Bearer' header, signed with the RSA or ECDSA key in this PEM file.
+Can't be used along with --htpasswd.`,
+	)
+	RootCmd.Flags().StringVar(&jwtHMACKey, "jwt-hmac-key", "", `
+Require a JWT in the 'Authorization: Bearer' header, signed with the HMAC secret in this file.
+Can't be used along with --htpasswd.`,
+	)
+	RootCmd.Flags().StringVar(&jwtJWKS, "jwt-jwks", "", `
+Require a JWT in the 'Authorization: Bearer' header, signed with one of the keys in this JWKS file.
+Can't be used along with --htpasswd.`,
+	)
+	RootCmd.Flags().StringVar(&jwtAudience, "jwt-aud", "", `
+Audience JWTs must be issued for.
+See also: --jwt-key, --jwt-hmac-key, --jwt-jwks.`,
+	)
+	RootCmd.Flags().StringVar(&jwtIssuer, "jwt-iss", "", `
+Issuer JWTs must be issued by.
+See also: --jwt-key, --jwt-hmac-key, --jwt-jwks.`,
+	)
+	RootCmd.Flags().StringArrayVar(&jwtClaims, "jwt-claim", nil, `
+JWT claim to pass on to the executable in a JWT_CLAIM_<NAME> environment variable.
+See also: --jwt-key, --jwt-hmac-key, --jwt-jwks.`,
+	)
+	RootCmd.Flags().StringVar(&jwtUserClaim, "jwt-user-claim", "sub", `
+JWT claim to pass on to the executable in REMOTE_USER.
+See also: --jwt-key, --jwt-hmac-key, --jwt-jwks.`,
+	)
+
+	RootCmd.Flags().StringArrayVar(&routes, "route", nil, `
+Serve an executable under a path, in the form 'PATH=EXECUTABLE[;methods=METHOD,...][;output=MODE]',
+e.g. '/report=./report.sh;methods=GET,POST;output=replace'.
+MODE is one of 'ez', 'replace', 'cgi', 'sse', 'json' or 'ndjson'.
+Routes are configured by the other flags, which methods and output override.
+If an executable is also given, it's served under every path not taken by a route.`,
+	)
+
+	RootCmd.Flags().StringArrayVar(&argsTemplate, "args-template", nil, `
+Command line argument built from each request, passed on to the executable after its own arguments.
+May be repeated, each flag being a single argument even if it contains spaces.
+Placeholders are written {SOURCE.NAME[:TYPE[:DEFAULT]]}, e.g. --args-template '--limit={query.limit:int:100}'.
+SOURCE is one of 'query', 'path', 'header' or 'json' and TYPE one of 'string', 'int', 'float' or 'bool'.
+Requests with missing or invalid values are rejected with a 400.`,
+	)
+
+	RootCmd.Flags().BoolVar(&paramArgs, "param-args", false, `
+Pass on the values of path parameters, such as id in '/users/{id}', to the executable as command line arguments too.
+They are always passed on in CGI_PARAM_<NAME> environment variables.`,
+	)
+
+	RootCmd.Flags().StringArrayVar(&statics, "static", nil, `
+Serve the files in a directory under a path, in the form 'PATH=DIR', e.g. '/assets=./public'.
+Directories are served through their index file, see --static-index.`,
+	)
+
+	RootCmd.Flags().StringSliceVar(&staticIndex, "static-index", []string{"index.html"}, `
+Names of the files served for a directory by --static, the first one found is served.`,
+	)
+
+	RootCmd.Flags().StringVar(&staticPrecedence, "static-precedence", "static", `
+Which of static files or executables take precedence when both could serve a request.
+'static' serves static files first, 'cgi' only serves a static file if the executable responds with a 404.`,
+	)
 
 	RootCmd.Flags().StringArrayVarP(&rawHeaders, "header", "H", nil, `
 HTTP header to send to client.
 To allow executable to override header see the --replace flag.
 Must be in the form 'KEY: VALUE'.`,
+	)
+	RootCmd.Flags().StringVar(&headerPolicy, "header-policy", "override", `
+How headers written by the executable are merged with the --header, -H headers.
+One of 'override', 'append' or 'locked'.
+See also: --replace, -r.`,
+	)
+	RootCmd.Flags().StringArrayVar(&headerAllow, "header-allow", nil, `
+Header the executable is allowed to set, all others are ignored.
+See also: --replace, -r.`,
+	)
+	RootCmd.Flags().StringArrayVar(&headerDeny, "header-deny", nil, `
+Header the executable is not allowed to set.
+See also: --replace, -r.`,
 	)
 	RootCmd.Flags().BoolVarP(&replace, "replace", "r", false, `
 Allow executable to replace default header values.
@@ -86,6 +381,53 @@ Conform to the CGI standard (expect for in the handling of the 'Location' header
 This flag overrides the --replace, -r flag.`,
 	)
 
+	RootCmd.Flags().BoolVar(&sse, "sse", false, `
+Stream each line of output to the client as a Server-Sent Event.
+Lines starting with 'event:' or 'id:' set the type and id of the next event.
+This flag overrides the --replace, -r and --cgi, -C flags.`,
+	)
+
+	RootCmd.Flags().BoolVar(&jsonOutput, "json", false, `
+Expect the executable to write a single JSON object describing the response:
+{"status": 200, "headers": {"KEY": "VALUE"}, "body": "...", "body_base64": "..."}
+This flag overrides the --replace, -r, --cgi, -C and --sse flags.`,
+	)
+	RootCmd.Flags().BoolVar(&ndjson, "ndjson", false, `
+Like --json but each line after the first JSON object is a body chunk that is streamed to the client:
+{"body": "..."} or {"body_base64": "..."}
+This flag overrides the --replace, -r, --cgi, -C, --sse and --json flags.`,
+	)
+
+	RootCmd.Flags().BoolVar(&buffered, "buffered", false, `
+Hold back the response until the executable exits so its exit code can decide the status code.
+A non-zero exit code results in a 500 unless mapped otherwise with the --exit-status flag.`,
+	)
+	RootCmd.Flags().StringArrayVar(&exitStatus, "exit-status", nil, `
+Map an exit code to an HTTP status code, implies --buffered.
+Must be in the form 'CODE=STATUS', e.g. '3=404'.`,
+	)
+	RootCmd.Flags().StringVar(&bufferMemory, "buffer-memory", "1M", `
+How much of a buffered response is kept in memory before spilling over to disk.
+Accepts K, M and G suffixes.
+See also: --buffered.`,
+	)
+
+	RootCmd.Flags().BoolVar(&websocket, "websocket", false, `
+Serve WebSocket connections instead of plain HTTP requests.
+Each connection starts one long-lived instance of the executable.
+Each message from the client is written to the executable's stdin as a line,
+each line the executable writes to its stdout is sent back as a message.`,
+	)
+	RootCmd.Flags().StringArrayVar(&wsOrigins, "ws-origin", nil, `
+Origin allowed to open WebSocket connections, '*' allows any origin.
+By default only same-origin connections are allowed.
+See also: --websocket.`,
+	)
+	RootCmd.Flags().Int64Var(&wsMaxMessage, "ws-max-message", cgi.DefaultWebSocketMaxMessageSize, `
+Maximum size in bytes of a message sent by a WebSocket client.
+See also: --websocket.`,
+	)
+
 	RootCmd.Flags().StringVarP(&shell, "shell", "s", "/bin/sh", `
 Which shell ez-cgi should use when a shell command is passed.
 See also: --shell-command, -S.`,
@@ -99,110 +441,387 @@ See also: --shell, -S.`,
 
 	RootCmd.Flags().StringArrayVarP(&envVars, "env-var", "e", nil, `
 Environment variable to pass on to the executable.
-Must be in the form 'KEY=VALUE'.`,
+'KEY=VALUE' sets KEY to VALUE, 'KEY' passes on ez-cgi's own value of KEY.
+Earlier versions only took 'KEY' and ignored 'KEY=VALUE'.`,
+	)
+
+	RootCmd.Flags().StringVar(&maxRequestBody, "max-request-body", "0", `
+Maximum size of a request body, larger requests get a 413 response.
+Accepts K, M and G suffixes, 0 means no limit.`,
+	)
+	RootCmd.Flags().StringVar(&maxHeaderSize, "max-header-size", "64K", `
+Maximum size of the header block written by the executable.
+Accepts K, M and G suffixes.`,
+	)
+	RootCmd.Flags().StringVar(&maxResponseBody, "max-response-body", "0", `
+Maximum size of the executable's output, once reached the executable is killed and the response truncated.
+Accepts K, M and G suffixes, 0 means no limit.
+See also: --abort-response.`,
+	)
+	RootCmd.Flags().BoolVar(&abortResponse, "abort-response", false, `
+Abort the connection instead of truncating the response when --max-response-body is reached.`,
+	)
+
+	RootCmd.Flags().BoolVar(&trailers, "trailers", false, `
+Let the executable send HTTP trailers after the body.
+Trailers must be declared in a 'Trailer' header and their values written to the
+file descriptor in the CGI_TRAILER_FD environment variable, one 'KEY: VALUE' per line.
+Only supported together with the --replace, -r and --cgi, -C flags.`,
 	)
 
 	RootCmd.Flags().StringVarP(&stderr, "stderr", "E", "", `
 Where to redirect executable's stderr.`)
 
+	RootCmd.Flags().DurationVar(&timeout, "timeout", 0, `
+How long the executable may run for handling a request before it's killed, e.g. 30s.
+0 means no limit.`,
+	)
+
+	RootCmd.Flags().DurationVar(&drainTimeout, "drain-timeout", defaultDrainTimeout, `
+How long executables still running on shutdown are waited on before they're sent SIGTERM, e.g. 1m.
+Executables still running 5s after being sent SIGTERM are killed.`,
+	)
+
 	RootCmd.Flags().StringVarP(&dir, "dir", "d", "", `
 Working directory for the executable.
 Defaults to where ez-cgi was called.`,
 	)
+
+	configCmd.AddCommand(configCheckCmd)
+	RootCmd.AddCommand(configCmd)
 }
 
 func run(cmd *cobra.Command, args []string) {
+	live := &liveServer{}
+	if configFile != "" {
+		if len(args) > 0 || cmd.Flags().NFlag() > 1 {
+			log.Println("--config can't be used along with an executable or any other flag")
+			os.Exit(1)
+		}
+		live.load = func() (*config, error) {
+			return loadConfig(configFile)
+		}
+	} else {
+		if len(args) == 0 && len(routes) == 0 && len(statics) == 0 {
+			os.Exit(0)
+		}
+		live.load = func() (*config, error) {
+			return configFromFlags(args)
+		}
+	}
+
+	if err := live.reload(); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	c := live.config
+	listeners, err := c.listen()
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	tlsConf := live.tlsConfig()
+	servers := make([]*http.Server, len(listeners))
+	errChan := make(chan error, len(listeners))
+	for i, l := range listeners {
+		servers[i] = c.httpServer(live, tlsConf)
+		go func(server *http.Server, l net.Listener) {
+			if tlsConf != nil {
+				errChan <- server.ServeTLS(l, "", "")
+			} else {
+				errChan <- server.Serve(l)
+			}
+		}(servers[i], l)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	exitChan := make(chan int)
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	go func() {
+		for sig := range sigChan {
+			if sig == syscall.SIGHUP {
+				if err := live.reload(); err != nil {
+					log.Printf("error reloading config, keeping the previous one:\n%s", err)
+				} else {
+					log.Println("config reloaded")
+				}
+				continue
+			}
+			signal.Stop(sigChan)
+			exitChan <- shutdown(servers, live, live.config.drainTimeout())
+		}
+	}()
+
+	if err := <-errChan; err != http.ErrServerClosed {
+		log.Println(err)
+		os.Exit(1)
+	}
+	os.Exit(<-exitChan)
+}
+
+// killDelay is how long executables are given to exit after being sent SIGTERM on shutdown before they're killed.
+const killDelay = 5 * time.Second
+
+// shutdown stops servers from accepting new requests and waits up to timeout for the executables in flight to finish.
+// Executables still running by then are sent SIGTERM, and are killed if they're still running killDelay later.
+// It returns the status ez-cgi should exit with: 0 if every executable finished on its own, 1 otherwise.
+func shutdown(servers []*http.Server, live *liveServer, timeout time.Duration) int {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			errs <- server.Shutdown(ctx)
+		}(server)
+	}
 	var err error
-	argLen := len(args)
-	if argLen == 0 {
-		os.Exit(0)
+	for range servers {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	// Shutdown doesn't wait on hijacked connections, such as WebSockets, so the executables are waited on directly too.
+	if err == nil {
+		err = live.wait(ctx)
+	}
+	if err == nil {
+		return 0
+	}
+	if err != context.DeadlineExceeded {
+		log.Printf("error shutting down: %v", err)
 	}
-	s := newServer()
-	s.port = port
 
-	handler := &cgi.Handler{
-		InheritEnv: envVars,
+	if n := live.signal(syscall.SIGTERM); n > 0 {
+		log.Printf("%d executables still running after %s, sending them SIGTERM", n, timeout)
+		ctx, cancel := context.WithTimeout(context.Background(), killDelay)
+		defer cancel()
+		if live.wait(ctx) != nil {
+			n = live.signal(os.Kill)
+			log.Printf("%d executables still running %s after SIGTERM, killing them", n, killDelay)
+			// Give the killed executables' requests a moment to wind down.
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			live.wait(ctx)
+		}
+	}
+	for _, server := range servers {
+		server.Close()
 	}
+	return 1
+}
 
-	if shellCommand {
-		handler.Path = shell
-		handler.Args = []string{"-c", args[0]}
-	} else {
-		handler.Path = args[0]
-		if argLen >= 2 {
-			handler.Args = args[1:argLen]
+// configFromFlags builds a config serving the executable in args according to the command line flags.
+func configFromFlags(args []string) (*config, error) {
+	c := &config{
+		Listen: []string{":" + port},
+		UnixSocket: unixSocketConfig{
+			Mode:  socketMode,
+			Owner: socketOwner,
+			Group: socketGroup,
+		},
+		Timeouts: timeoutsConfig{Drain: duration(drainTimeout)},
+		Quiet:    noError,
+	}
+	if len(listenAddrs) > 0 {
+		c.Listen = listenAddrs
+	}
+
+	if tlsClientCA != "" && (certFile == "" || keyFile == "") {
+		return nil, errors.New("--tls-client-ca requires --tls-cert and --tls-key")
+	}
+	if certFile != "" && keyFile != "" {
+		c.TLS = &tlsConfig{
+			Cert:     certFile,
+			Key:      keyFile,
+			ClientCA: tlsClientCA,
+		}
+		if tlsClientCA != "" {
+			c.TLS.ClientAuth = tlsClientAuth
 		}
 	}
 
-	if stderr != "" {
-		handler.Stderr, err = os.Open(stderr)
-		defer handler.Stderr.(io.WriteCloser).Close()
+	rc := routeConfig{
+		Path:           "/",
+		ArgsTemplate:   argsTemplate,
+		ParamArgs:      paramArgs,
+		Dir:            dir,
+		Stderr:         stderr,
+		Env:            envVars,
+		HeaderPolicy:   headerPolicy,
+		HeaderAllow:    headerAllow,
+		HeaderDeny:     headerDeny,
+		Buffered:       buffered,
+		Trailers:       trailers,
+		Timeout:        duration(timeout),
+		IPRules:        ipRules,
+		IPRulesFile:    ipRulesFile,
+		TrustedProxies: trustedProxies,
+	}
+	if rc.Dir == "" {
+		wd, err := os.Getwd()
 		if err != nil {
-			log.Printf("error opening stderr: %s", err.Error())
-			os.Exit(1)
+			return nil, fmt.Errorf("error getting working directory: %s", err.Error())
 		}
+		rc.Dir = wd
 	}
 
-	header := http.Header{}
+	rc.Headers = make(map[string]string)
 	for _, rh := range rawHeaders {
 		parts := strings.SplitN(rh, ":", 2)
 		if len(parts) < 2 {
-			log.Printf("invalid header: %s", rh)
-			os.Exit(1)
+			return nil, fmt.Errorf("invalid header: %s", rh)
 		}
-		k := strings.TrimSpace(parts[0])
-		v := strings.TrimSpace(parts[1])
-		header.Set(k, v)
+		rc.Headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
 	}
-	if len(header) != 0 {
-		handler.Header = header
+
+	switch {
+	case ndjson:
+		rc.Output = "ndjson"
+	case jsonOutput:
+		rc.Output = "json"
+	case sse:
+		rc.Output = "sse"
+	case conformCGI:
+		rc.Output = "cgi"
+	case replace:
+		rc.Output = "replace"
 	}
 
-	if dir != "" {
-		handler.Dir = dir
-	} else {
-		handler.Dir, err = os.Getwd()
+	statusMap, err := parseExitStatus(exitStatus)
+	if err != nil {
+		return nil, err
+	}
+	rc.ExitStatus = make(map[string]int, len(statusMap))
+	for code, status := range statusMap {
+		rc.ExitStatus[strconv.Itoa(code)] = status
+	}
+
+	for _, sz := range []struct {
+		name  string
+		value string
+		dst   *size
+	}{
+		{"buffer memory", bufferMemory, &rc.BufferMemory},
+		{"max request body", maxRequestBody, &rc.Limits.MaxRequestBody},
+		{"max header size", maxHeaderSize, &rc.Limits.MaxHeaderSize},
+		{"max response body", maxResponseBody, &rc.Limits.MaxResponseBody},
+	} {
+		n, err := parseSize(sz.value)
 		if err != nil {
-			log.Printf("error opening stderr: %s", err.Error())
-			os.Exit(1)
+			return nil, fmt.Errorf("invalid %s: %s", sz.name, err)
 		}
+		*sz.dst = size(n)
 	}
+	rc.Limits.AbortResponse = abortResponse
 
-	if !noError {
-		handler.Logger = log.New(os.Stderr, "\nerror :: ", log.LstdFlags)
+	if websocket {
+		rc.WebSocket = &websocketConfig{
+			Origins:    wsOrigins,
+			MaxMessage: size(wsMaxMessage),
+		}
 	}
 
-	if replace {
-		handler.OutputHandler = cgi.EZOutputHandlerReplacer
+	if queryEnv || formEnv {
+		maxSize, err := parseSize(formMaxSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid form max size: %s", err)
+		}
+		rc.FormEnv = &formEnvConfig{
+			Query:       queryEnv,
+			Form:        formEnv,
+			MultiValue:  formMultiValue,
+			Separator:   formSeparator,
+			MaxFormSize: size(maxSize),
+		}
 	}
 
-	if conformCGI {
-		handler.OutputHandler = cgi.DefaultOutputHandler
+	if uploads {
+		maxFileSize, err := parseSize(uploadMaxFileSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upload max file size: %s", err)
+		}
+		rc.Uploads = &uploadsConfig{
+			Dir:         uploadDir,
+			MaxFileSize: size(maxFileSize),
+			MaxFiles:    uploadMaxFiles,
+			Manifest:    uploadManifest,
+		}
 	}
 
-	if handler.OutputHandler == nil {
-		handler.OutputHandler = cgi.EZOutputHandler
+	jwt := jwtKey != "" || jwtHMACKey != "" || jwtJWKS != ""
+	if htpasswd != "" && jwt {
+		return nil, errors.New("--htpasswd can't be used along with JWT authentication")
+	}
+	if htpasswd != "" {
+		rc.Auth = &authConfig{Realm: authRealm, Htpasswd: htpasswd}
+	}
+	if jwt {
+		rc.Auth = &authConfig{
+			Realm: authRealm,
+			JWT: &jwtConfig{
+				Key:       jwtKey,
+				HMACKey:   jwtHMACKey,
+				JWKS:      jwtJWKS,
+				Audience:  jwtAudience,
+				Issuer:    jwtIssuer,
+				Claims:    jwtClaims,
+				UserClaim: jwtUserClaim,
+			},
+		}
 	}
 
-	server := &http.Server{
-		Addr:    ":" + port,
-		Handler: handler,
+	if len(corsOrigins) > 0 {
+		rc.CORS = &corsConfig{
+			Origins:     corsOrigins,
+			Methods:     corsMethods,
+			Headers:     corsHeaders,
+			Expose:      corsExpose,
+			Credentials: corsCredentials,
+			MaxAge:      duration(corsMaxAge),
+		}
 	}
 
-	sigChan := make(chan os.Signal)
-	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
-	go func() {
-		<-sigChan
-		server.Shutdown(cmd.Context())
-		os.Exit(0)
-	}()
+	if rateLimit != "" {
+		rc.RateLimit = &rateLimitConfig{
+			Rate:  rateLimit,
+			Burst: rateBurst,
+			By:    rateLimitBy,
+		}
+	}
 
-	if certFile != "" && keyFile != "" {
-		server.ListenAndServeTLS(certFile, keyFile)
-	} else {
-		server.ListenAndServe()
+	for _, r := range routes {
+		route, err := parseRoute(r, rc)
+		if err != nil {
+			return nil, err
+		}
+		c.Routes = append(c.Routes, route)
+	}
+
+	for _, st := range statics {
+		parts := strings.SplitN(st, "=", 2)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid static directory: %q: expected 'PATH=DIR'", st)
+		}
+		c.Static = append(c.Static, staticConfig{
+			Path:       parts[0],
+			Dir:        parts[1],
+			Index:      staticIndex,
+			Precedence: staticPrecedence,
+		})
 	}
-	os.Exit(0)
+
+	if len(args) > 0 {
+		rc.Exec, rc.Args = args[0], args[1:]
+		if shellCommand {
+			rc.Exec = shell
+			rc.Args = []string{"-c", args[0]}
+		}
+		c.Routes = append(c.Routes, rc)
+	}
+
+	return c, c.validate()
 }
 
 func Execute() {
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRouteFlags(t *testing.T) {
+	type test struct {
+		Name  string
+		Flags []string
+		// Expected maps requests, written as 'METHOD PATH', to the status they should be responded to with.
+		Expected map[string]int
+		// ExpectedError is what the error must end with, empty if the flags are valid.
+		ExpectedError string
+	}
+
+	tt := []test{
+		test{
+			Name: "Routes",
+			Flags: []string{
+				"--route", "/read=./script.sh;methods=GET",
+				"--route", "/write=./script.sh;methods=POST;output=replace",
+			},
+			Expected: map[string]int{
+				"GET /read":   http.StatusOK,
+				"HEAD /read":  http.StatusOK,
+				"POST /read":  http.StatusMethodNotAllowed,
+				"POST /write": http.StatusOK,
+				"GET /write":  http.StatusMethodNotAllowed,
+				"GET /other":  http.StatusNotFound,
+			},
+		},
+		test{
+			Name: "Duplicate routes",
+			Flags: []string{
+				"--route", "/read=./script.sh",
+				"--route", "/read/=./script.sh",
+			},
+			ExpectedError: "/read/ is already served by routes[0]",
+		},
+		test{
+			Name:          "Invalid route",
+			Flags:         []string{"--route", "/read"},
+			ExpectedError: `invalid route: "/read": expected 'PATH=EXECUTABLE'`,
+		},
+	}
+
+	defer RootCmd.ResetFlags()
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			path, cleanup := writeConfig(t, "unused", "")
+			defer cleanup()
+
+			RootCmd.ResetFlags()
+			SetFlags()
+			if err := RootCmd.ParseFlags(append(tc.Flags, "--dir", filepath.Dir(path))); err != nil {
+				t.Fatalf("error while parsing flags: %s", err)
+			}
+
+			c, err := configFromFlags(nil)
+			var s *server
+			if err == nil {
+				s, err = newServer(c)
+			}
+			switch {
+			case tc.ExpectedError == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tc.ExpectedError != "" && err == nil:
+				s.close()
+				t.Fatalf("expected error %q, got none", tc.ExpectedError)
+			case tc.ExpectedError != "":
+				if !strings.HasSuffix(err.Error(), tc.ExpectedError) {
+					t.Fatalf("expected error ending with %q, got %q", tc.ExpectedError, err.Error())
+				}
+				return
+			}
+			defer s.close()
+
+			for req, expected := range tc.Expected {
+				parts := strings.SplitN(req, " ", 2)
+				w := httptest.NewRecorder()
+				s.ServeHTTP(w, httptest.NewRequest(parts[0], parts[1], nil))
+				if w.Code != expected {
+					t.Fatalf("wrong status for %s - expected: %d\treceived: %d", req, expected, w.Code)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"github.com/raphaelreyna/ez-cgi/pkg/cgi"
+	"net/http"
+	"sort"
+)
+
+// router dispatches requests to the route whose path pattern matches them most specifically.
+type router struct {
+	routes []routerEntry
+}
+
+type routerEntry struct {
+	pattern *cgi.Pattern
+	handler http.Handler
+}
+
+// handle adds a route, patterns are expected to have been checked for conflicts already.
+func (rt *router) handle(p *cgi.Pattern, h http.Handler) {
+	rt.routes = append(rt.routes, routerEntry{pattern: p, handler: h})
+	sort.SliceStable(rt.routes, func(i, j int) bool {
+		return rt.routes[i].pattern.Precedes(rt.routes[j].pattern)
+	})
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, e := range rt.routes {
+		if _, _, ok := e.pattern.Match(r.URL.Path); ok {
+			e.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
@@ -1,23 +1,458 @@
 package cmd
 
 import (
+	"crypto/tls"
+	"fmt"
 	"github.com/raphaelreyna/ez-cgi/pkg/cgi"
+	"io/ioutil"
+	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+// server serves every route of a config.
 type server struct {
-	mux        *http.ServeMux
-	port       string
-	cgiHandler *cgi.Handler
+	mux *http.ServeMux
+	// tls is the TLS config for the server, nil if it doesn't use TLS.
+	tls *tls.Config
+	// files are the files opened for the routes, closed along with the server.
+	files []*os.File
+	// handlers are the handlers of every route.
+	handlers []*cgi.Handler
+
+	// mu guards active and retired, which keep track of when a replaced server can be closed.
+	mu      sync.Mutex
+	active  int
+	retired bool
 }
 
-func newServer() *server {
-	mux := http.NewServeMux()
-	return &server{
-		mux: mux,
+// newServer builds the handlers for every route in c.
+// Errors are located in c, which should have been validated already.
+func newServer(c *config) (*server, error) {
+	s := &server{mux: http.NewServeMux()}
+
+	routes := &router{}
+	var errs configErrors
+	for i := range c.Routes {
+		h, err := s.route(c, i)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		p, err := cgi.ParsePattern(routePath(c.Routes[i]))
+		if err != nil {
+			errs = append(errs, c.errorf(fmt.Sprintf("routes[%d].path", i), "%v", err))
+			continue
+		}
+		routes.handle(p, h)
+	}
+	if len(errs) > 0 {
+		s.close()
+		return nil, errs
+	}
+
+	// Static directories are mounted in front of the routes, which handle whatever isn't a static file.
+	mountedRoot := false
+	for _, st := range c.Static {
+		root := staticPath(st)
+		sh := &cgi.StaticHandler{
+			Dir:          c.resolve(st.Dir),
+			Root:         root,
+			Index:        st.Index,
+			Handler:      routes,
+			HandlerFirst: st.Precedence == "cgi",
+		}
+		if root == "/" {
+			s.mux.Handle("/", sh)
+			mountedRoot = true
+			continue
+		}
+		s.mux.Handle(root, sh)
+		s.mux.Handle(root+"/", sh)
+	}
+	if !mountedRoot {
+		s.mux.Handle("/", routes)
 	}
+
+	return s, nil
+}
+
+// routePath returns the path rc is mounted at, without any trailing slash.
+func routePath(rc routeConfig) string {
+	root := strings.TrimSuffix(rc.Path, "/")
+	if root == "" {
+		return "/"
+	}
+	return root
+}
+
+// staticPath returns the path st is mounted at, without any trailing slash.
+func staticPath(st staticConfig) string {
+	root := strings.TrimSuffix(st.Path, "/")
+	if root == "" {
+		return "/"
+	}
+	return root
 }
 
 func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.cgiHandler.ServeHTTP(w, r)
+	s.mux.ServeHTTP(w, r)
+}
+
+// close releases the resources held by the server's routes.
+func (s *server) close() {
+	for _, f := range s.files {
+		f.Close()
+	}
+}
+
+// acquire marks the start of a request, it returns false if the server has been retired and shouldn't be used anymore.
+func (s *server) acquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.retired {
+		return false
+	}
+	s.active++
+	return true
+}
+
+// release marks the end of a request started with acquire.
+func (s *server) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	if s.retired && s.active == 0 {
+		s.close()
+	}
+}
+
+// done reports whether the server has been retired and is done serving requests.
+func (s *server) done() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.retired && s.active == 0
+}
+
+// retire stops new requests from being served by s, which is closed once its in-flight requests are done.
+func (s *server) retire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retired = true
+	if s.active == 0 {
+		s.close()
+	}
+}
+
+// route builds the handler for the i-th route in c.
+func (s *server) route(c *config, i int) (http.Handler, error) {
+	rc := c.Routes[i]
+	key := func(k string) string {
+		if k == "" {
+			return fmt.Sprintf("routes[%d]", i)
+		}
+		return fmt.Sprintf("routes[%d].%s", i, k)
+	}
+
+	opts := []cgi.Option{
+		cgi.WithArgs(rc.Args...),
+		cgi.WithRoot(routePath(rc)),
+		cgi.WithMethods(rc.Methods...),
+	}
+	if len(rc.ArgsTemplate) > 0 {
+		opts = append(opts, cgi.WithArgTemplates(rc.ArgsTemplate...))
+	}
+	if rc.ParamArgs {
+		opts = append(opts, cgi.WithParamArgs())
+	}
+
+	var env, inheritEnv []string
+	for _, e := range rc.Env {
+		if strings.Contains(e, "=") {
+			env = append(env, e)
+		} else {
+			inheritEnv = append(inheritEnv, e)
+		}
+	}
+	opts = append(opts, cgi.WithEnv(env...), cgi.WithInheritEnv(inheritEnv...))
+
+	if rc.Dir != "" {
+		opts = append(opts, cgi.WithDir(c.resolve(rc.Dir)))
+	} else if c.file != "" {
+		opts = append(opts, cgi.WithDir(c.resolve(".")))
+	}
+
+	if rc.Stderr != "" {
+		f, err := os.OpenFile(c.resolve(rc.Stderr), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, c.errorf(key("stderr"), "error opening stderr: %v", err)
+		}
+		s.files = append(s.files, f)
+		opts = append(opts, cgi.WithStderr(f))
+	}
+	if c.Quiet {
+		// Handlers without a Logger log with the log package, which isn't quiet.
+		opts = append(opts, cgi.WithLogger(log.New(ioutil.Discard, "", 0)))
+	} else {
+		opts = append(opts, cgi.WithLogger(log.New(os.Stderr, "\nerror :: ", log.LstdFlags)))
+	}
+
+	if len(rc.Headers) > 0 {
+		header := http.Header{}
+		for k, v := range rc.Headers {
+			header.Set(k, v)
+		}
+		opts = append(opts, cgi.WithHeader(header))
+	}
+	if rc.HeaderPolicy != "" {
+		policy, err := parseHeaderPolicy(rc.HeaderPolicy)
+		if err != nil {
+			return nil, c.errorf(key("header_policy"), "%v", err)
+		}
+		opts = append(opts, cgi.WithHeaderPolicy(policy))
+	}
+	opts = append(opts,
+		cgi.WithHeaderAllow(rc.HeaderAllow...),
+		cgi.WithHeaderDeny(rc.HeaderDeny...),
+	)
+
+	outputHandler, ok := outputModes[rc.Output]
+	if !ok {
+		return nil, c.errorf(key("output"), "invalid output mode: %q", rc.Output)
+	}
+	if rc.Buffered || len(rc.ExitStatus) > 0 {
+		statusMap := make(map[int]int, len(rc.ExitStatus))
+		for code, status := range rc.ExitStatus {
+			n, err := strconv.Atoi(code)
+			if err != nil {
+				return nil, c.errorf(key("exit_status."+code), "invalid exit code")
+			}
+			statusMap[n] = status
+		}
+		outputHandler = &cgi.BufferedOutputHandler{
+			OutputHandler: outputHandler,
+			ExitStatus:    statusMap,
+			MemoryLimit:   int64(rc.BufferMemory),
+		}
+	}
+	opts = append(opts, cgi.WithOutputHandler(outputHandler))
+
+	if rc.Trailers {
+		opts = append(opts, cgi.WithTrailers())
+	}
+	opts = append(opts,
+		cgi.WithTimeout(time.Duration(rc.Timeout)),
+		cgi.WithMaxRequestBodySize(int64(rc.Limits.MaxRequestBody)),
+		cgi.WithMaxHeaderSize(int(rc.Limits.MaxHeaderSize)),
+		cgi.WithMaxResponseSize(int64(rc.Limits.MaxResponseBody), rc.Limits.AbortResponse),
+	)
+
+	if f := rc.FormEnv; f != nil {
+		fe := &cgi.FormEnv{
+			Query:        f.Query,
+			Form:         f.Form,
+			Separator:    f.Separator,
+			MaxFormSize:  int64(f.MaxFormSize),
+			MaxValueSize: int(f.MaxValueSize),
+			MaxParams:    f.MaxParams,
+		}
+		if f.MultiValue != "" {
+			fe.MultiValue, _ = cgi.ParseMultiValuePolicy(f.MultiValue)
+		}
+		opts = append(opts, cgi.WithFormEnv(fe))
+	}
+
+	if u := rc.Uploads; u != nil {
+		uploads := &cgi.Uploads{
+			MaxFileSize:   int64(u.MaxFileSize),
+			MaxFiles:      u.MaxFiles,
+			MaxFieldsSize: int64(u.MaxFieldsSize),
+			Manifest:      u.Manifest,
+		}
+		if u.Dir != "" {
+			uploads.Dir = c.resolve(u.Dir)
+		}
+		opts = append(opts, cgi.WithUploads(uploads))
+	}
+
+	if rc.CORS != nil {
+		opts = append(opts, cgi.WithCORS(&cgi.CORS{
+			AllowedOrigins:   rc.CORS.Origins,
+			AllowedMethods:   rc.CORS.Methods,
+			AllowedHeaders:   rc.CORS.Headers,
+			ExposedHeaders:   rc.CORS.Expose,
+			AllowCredentials: rc.CORS.Credentials,
+			MaxAge:           time.Duration(rc.CORS.MaxAge),
+		}))
+	}
+
+	if rl := rc.RateLimit; rl != nil {
+		rate, err := parseRate(rl.Rate)
+		if err != nil {
+			return nil, c.errorf(key("rate_limit.rate"), "%v", err)
+		}
+		by := rl.By
+		if by == "" {
+			by = "ip"
+		}
+		k, header, err := parseRateLimitKey(by)
+		if err != nil {
+			return nil, c.errorf(key("rate_limit.by"), "%v", err)
+		}
+		burst := rl.Burst
+		if burst == 0 {
+			burst = 1
+		}
+		opts = append(opts, cgi.WithRateLimit(&cgi.RateLimiter{
+			Rate:   rate,
+			Burst:  burst,
+			Key:    k,
+			Header: header,
+		}))
+	}
+
+	if len(rc.IPRules) > 0 || rc.IPRulesFile != "" {
+		var rules []cgi.IPRule
+		for j, s := range rc.IPRules {
+			rule, err := cgi.ParseIPRule(s)
+			if err != nil {
+				return nil, c.errorf(fmt.Sprintf("%s[%d]", key("ip_rules"), j), "%v", err)
+			}
+			rules = append(rules, rule)
+		}
+		filter := &cgi.IPFilter{Rules: rules}
+		if rc.IPRulesFile != "" {
+			var err error
+			filter, err = cgi.LoadIPFilter(c.resolve(rc.IPRulesFile), rules...)
+			if err != nil {
+				return nil, c.errorf(key("ip_rules_file"), "%v", err)
+			}
+		}
+		opts = append(opts, cgi.WithIPFilter(filter))
+	}
+	opts = append(opts, cgi.WithTrustedProxies(rc.TrustedProxies...))
+
+	if a := rc.Auth; a != nil {
+		realm := a.Realm
+		if realm == "" {
+			realm = "ez-cgi"
+		}
+		var auth cgi.Authenticator
+		var err error
+		if a.Htpasswd != "" {
+			auth, err = cgi.NewHtpasswdAuth(c.resolve(a.Htpasswd), realm)
+			if err != nil {
+				return nil, c.errorf(key("auth.htpasswd"), "%v", err)
+			}
+		} else {
+			auth, err = c.jwtAuth(a.JWT, realm)
+			if err != nil {
+				return nil, c.errorf(key("auth.jwt"), "%v", err)
+			}
+		}
+		opts = append(opts, cgi.WithAuth(auth))
+	}
+
+	h, err := cgi.New(rc.Exec, opts...)
+	if err != nil {
+		return nil, c.errorf(key("exec"), "%v", err)
+	}
+	s.handlers = append(s.handlers, h)
+
+	if ws := rc.WebSocket; ws != nil {
+		return &cgi.WebSocketHandler{
+			Handler:        h,
+			AllowedOrigins: ws.Origins,
+			MaxMessageSize: int64(ws.MaxMessage),
+		}, nil
+	}
+	return h, nil
+}
+
+// jwtAuth builds a JWT authenticator from jc.
+func (c *config) jwtAuth(jc *jwtConfig, realm string) (*cgi.JWTAuth, error) {
+	auth := &cgi.JWTAuth{
+		Audience:  jc.Audience,
+		Issuer:    jc.Issuer,
+		Claims:    jc.Claims,
+		UserClaim: jc.UserClaim,
+		Realm:     realm,
+	}
+
+	if jc.Key != "" {
+		data, err := ioutil.ReadFile(c.resolve(jc.Key))
+		if err != nil {
+			return nil, fmt.Errorf("error reading JWT key: %s", err)
+		}
+		key, err := cgi.ParseJWTPublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("error reading JWT key: %s", err)
+		}
+		auth.Keys = append(auth.Keys, key)
+	}
+	if jc.HMACKey != "" {
+		data, err := ioutil.ReadFile(c.resolve(jc.HMACKey))
+		if err != nil {
+			return nil, fmt.Errorf("error reading JWT HMAC key: %s", err)
+		}
+		data = []byte(strings.TrimRight(string(data), "\r\n"))
+		if len(data) == 0 {
+			return nil, fmt.Errorf("error reading JWT HMAC key: %s is empty", jc.HMACKey)
+		}
+		auth.Keys = append(auth.Keys, cgi.JWTKey{Key: data})
+	}
+	if jc.JWKS != "" {
+		data, err := ioutil.ReadFile(c.resolve(jc.JWKS))
+		if err != nil {
+			return nil, fmt.Errorf("error reading JWKS: %s", err)
+		}
+		keys, err := cgi.ParseJWKS(data)
+		if err != nil {
+			return nil, fmt.Errorf("error reading JWKS: %s", err)
+		}
+		auth.Keys = append(auth.Keys, keys...)
+	}
+
+	return auth, nil
+}
+
+// tlsConfig returns the TLS config for c, nil if c doesn't use TLS.
+func (c *config) tlsConfig() (*tls.Config, error) {
+	if c.TLS == nil {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.resolve(c.TLS.Cert), c.resolve(c.TLS.Key))
+	if err != nil {
+		return nil, c.errorf("tls", "error loading certificate: %v", err)
+	}
+
+	conf := &tls.Config{}
+	if c.TLS.ClientCA != "" {
+		mode := c.TLS.ClientAuth
+		if mode == "" {
+			mode = "required"
+		}
+		conf, err = clientTLSConfig(c.resolve(c.TLS.ClientCA), mode)
+		if err != nil {
+			return nil, c.errorf("tls.client_ca", "%v", err)
+		}
+	}
+	conf.Certificates = []tls.Certificate{cert}
+	return conf, nil
+}
+
+// httpServer returns an HTTP server for handler configured by c.
+func (c *config) httpServer(handler http.Handler, tlsConf *tls.Config) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		TLSConfig:         tlsConf,
+		ReadTimeout:       time.Duration(c.Timeouts.Read),
+		ReadHeaderTimeout: time.Duration(c.Timeouts.ReadHeader),
+		WriteTimeout:      time.Duration(c.Timeouts.Write),
+		IdleTimeout:       time.Duration(c.Timeouts.Idle),
+	}
 }
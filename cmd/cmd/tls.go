@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// clientTLSConfig returns a TLS config verifying client certificates against the CA certificates in the file caFile.
+// mode is either "required" or "optional".
+func clientTLSConfig(caFile, mode string) (*tls.Config, error) {
+	var clientAuth tls.ClientAuthType
+	switch mode {
+	case "required":
+		clientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		clientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("invalid TLS client auth mode %q: must be one of 'required' or 'optional'", mode)
+	}
+
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading TLS client CA: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("error reading TLS client CA: no certificates found in %s", caFile)
+	}
+
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: clientAuth,
+	}, nil
+}
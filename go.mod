@@ -3,8 +3,11 @@ module github.com/raphaelreyna/ez-cgi
 go 1.14
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/spf13/cobra v1.0.0
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	golang.org/x/mod v0.3.0 // indirect
 	golang.org/x/net v0.0.0-20200602114024-627f9648deb9 // indirect
 	golang.org/x/tools v0.0.0-20200612220849-54c614fe050c // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
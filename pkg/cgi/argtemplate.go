@@ -0,0 +1,304 @@
+package cgi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ArgTemplate is a command line argument built from the request being handled, such as "--limit={query.limit:int:100}".
+// Each placeholder in the template is written as {SOURCE.NAME[:TYPE[:DEFAULT]]} where SOURCE is one of:
+//
+//	query    a query string parameter
+//	path     a parameter of the Handler's Root pattern
+//	header   a request header
+//	json     a field of the JSON request body, nested fields are separated by dots: {json.user.id}
+//
+// TYPE is one of string (the default), int, float or bool, values that aren't of the right type get the request rejected with a 400.
+// Placeholders without a DEFAULT are required, requests missing their value are rejected with a 400 too.
+// JSON bodies are read up to the Handler's MaxRequestBodySize, or DefaultMaxFormSize if it has none, larger ones are rejected with a 413.
+// Literal braces are written doubled: "{{" and "}}".
+//
+// The argument is passed on to the client process as a single argument, no shell is involved.
+// Since programs might mistake an argument starting with a "-" for an option, a string value that makes up a whole argument
+// isn't allowed to start with one.
+type ArgTemplate struct {
+	raw   string
+	parts []argPart
+}
+
+type argPart struct {
+	literal string
+	// source is empty for literal parts.
+	source   string
+	name     string
+	typ      string
+	def      string
+	required bool
+}
+
+// ParseArgTemplate parses a command line argument template, see ArgTemplate.
+func ParseArgTemplate(s string) (*ArgTemplate, error) {
+	t := &ArgTemplate{raw: s}
+	var lit strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '{' && strings.HasPrefix(s[i:], "{{"), c == '}' && strings.HasPrefix(s[i:], "}}"):
+			lit.WriteByte(c)
+			i++
+		case c == '}':
+			return nil, fmt.Errorf("cgi: invalid argument template %q: unexpected '}'", s)
+		case c == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end == -1 {
+				return nil, fmt.Errorf("cgi: invalid argument template %q: missing '}'", s)
+			}
+			p, err := parseArgPart(s[i+1 : i+end])
+			if err != nil {
+				return nil, fmt.Errorf("cgi: invalid argument template %q: %v", s, err)
+			}
+			if lit.Len() > 0 {
+				t.parts = append(t.parts, argPart{literal: lit.String()})
+				lit.Reset()
+			}
+			t.parts = append(t.parts, p)
+			i += end
+		default:
+			lit.WriteByte(c)
+		}
+	}
+	if lit.Len() > 0 || len(t.parts) == 0 {
+		t.parts = append(t.parts, argPart{literal: lit.String()})
+	}
+	return t, nil
+}
+
+func parseArgPart(s string) (argPart, error) {
+	fields := strings.SplitN(s, ":", 3)
+	ref := strings.SplitN(fields[0], ".", 2)
+	if len(ref) < 2 || ref[1] == "" {
+		return argPart{}, fmt.Errorf("placeholder {%s} must be in the form {SOURCE.NAME}", s)
+	}
+	p := argPart{source: ref[0], name: ref[1], typ: "string", required: true}
+	switch p.source {
+	case "query", "path", "header", "json":
+	default:
+		return argPart{}, fmt.Errorf("unknown source %q: must be one of 'query', 'path', 'header' or 'json'", p.source)
+	}
+	if len(fields) > 1 && fields[1] != "" {
+		p.typ = fields[1]
+	}
+	switch p.typ {
+	case "string", "int", "float", "bool":
+	default:
+		return argPart{}, fmt.Errorf("unknown type %q: must be one of 'string', 'int', 'float' or 'bool'", p.typ)
+	}
+	if len(fields) > 2 {
+		p.def, p.required = fields[2], false
+		if _, err := convertArg(p.typ, p.def); err != nil {
+			return argPart{}, fmt.Errorf("invalid default for {%s}: %v", fields[0], err)
+		}
+	}
+	return p, nil
+}
+
+// convertArg checks that v is of type typ, returning it in its canonical form.
+func convertArg(typ, v string) (string, error) {
+	switch typ {
+	case "string":
+		return v, nil
+	case "int":
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("%q is not an integer", v)
+		}
+		return strconv.FormatInt(n, 10), nil
+	case "float":
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a number", v)
+		}
+		return strconv.FormatFloat(f, 'g', -1, 64), nil
+	case "bool":
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a boolean", v)
+		}
+		return strconv.FormatBool(b), nil
+	}
+	return "", fmt.Errorf("unknown type %q", typ)
+}
+
+func (t *ArgTemplate) String() string {
+	return t.raw
+}
+
+// PathParams returns the names of the Root parameters t refers to.
+func (t *ArgTemplate) PathParams() []string {
+	var names []string
+	for _, p := range t.parts {
+		if p.source == "path" {
+			names = append(names, p.name)
+		}
+	}
+	return names
+}
+
+// checkParams makes sure every path parameter t refers to is one of root's, root may be nil.
+func (t *ArgTemplate) checkParams(root *Pattern) error {
+	for _, name := range t.PathParams() {
+		found := false
+		if root != nil {
+			for _, param := range root.params {
+				found = found || param == name
+			}
+		}
+		if !found {
+			return fmt.Errorf("cgi: invalid argument template %q: root has no parameter %q", t.raw, name)
+		}
+	}
+	return nil
+}
+
+// argSources holds the request data ArgTemplates are evaluated against.
+type argSources struct {
+	r      *http.Request
+	params map[string]string
+	// body is the decoded JSON request body, nil if there isn't one.
+	body interface{}
+}
+
+// newArgSources reads what's needed out of r to evaluate templates, including its JSON body if any template needs it.
+// r's body is replaced with a copy of itself once read.
+// If r should be rejected, the error is returned along with the status code to respond with.
+func (h *Handler) newArgSources(r *http.Request, templates []*ArgTemplate) (*argSources, int, error) {
+	src := &argSources{r: r, params: make(map[string]string)}
+	if p := h.rootPattern(); p != nil {
+		if values, _, ok := p.Match(r.URL.Path); ok {
+			for i, name := range p.params {
+				src.params[name] = values[i]
+			}
+		}
+	}
+
+	needsBody := false
+	for _, t := range templates {
+		for _, p := range t.parts {
+			needsBody = needsBody || p.source == "json"
+		}
+	}
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if !needsBody || r.Body == nil || r.ContentLength == 0 || (mt != "application/json" && !strings.HasSuffix(mt, "+json")) {
+		return src, 0, nil
+	}
+
+	// JSON bodies are held in memory, so unless the Handler has its own limit they're held to the same one as the form bodies parsed by FormEnv.
+	max := h.MaxRequestBodySize
+	if max <= 0 {
+		max = DefaultMaxFormSize
+	}
+	if r.ContentLength > max {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("JSON body larger than %d bytes", max)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, max+1))
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("error reading JSON body: %v", err)
+	}
+	if int64(len(body)) > max {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("JSON body larger than %d bytes", max)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&src.body); err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("malformed JSON body: %v", err)
+	}
+	return src, 0, nil
+}
+
+// lookup returns the value p refers to, and whether there is one.
+func (src *argSources) lookup(p argPart) (string, bool) {
+	switch p.source {
+	case "query":
+		values, ok := src.r.URL.Query()[p.name]
+		if !ok || len(values) == 0 {
+			return "", false
+		}
+		return values[0], true
+	case "path":
+		v, ok := src.params[p.name]
+		return v, ok
+	case "header":
+		values := src.r.Header.Values(p.name)
+		if len(values) == 0 {
+			return "", false
+		}
+		return values[0], true
+	}
+
+	v := src.body
+	for _, key := range strings.Split(p.name, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", false
+			}
+			v = node[i]
+		default:
+			return "", false
+		}
+	}
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		b, _ := json.Marshal(v)
+		return string(b), true
+	}
+}
+
+// eval builds the argument t describes out of src.
+func (t *ArgTemplate) eval(src *argSources) (string, error) {
+	var arg strings.Builder
+	for _, p := range t.parts {
+		if p.source == "" {
+			arg.WriteString(p.literal)
+			continue
+		}
+		v, ok := src.lookup(p)
+		if !ok {
+			if p.required {
+				return "", fmt.Errorf("missing %s %q", p.source, p.name)
+			}
+			v = p.def
+		}
+		v, err := convertArg(p.typ, v)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s %q: %v", p.source, p.name, err)
+		}
+		if strings.IndexByte(v, 0) != -1 {
+			return "", fmt.Errorf("invalid %s %q: contains a NUL byte", p.source, p.name)
+		}
+		if ok && len(t.parts) == 1 && p.typ == "string" && strings.HasPrefix(v, "-") {
+			return "", fmt.Errorf("invalid %s %q: must not start with '-'", p.source, p.name)
+		}
+		arg.WriteString(v)
+	}
+	return arg.String(), nil
+}
@@ -0,0 +1,160 @@
+package cgi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseArgTemplate(t *testing.T) {
+	valid := []string{
+		"--user",
+		"{query.user}",
+		"--limit={query.limit:int:100}",
+		"{json.user.id:int}",
+		"{header.X-Request-Id:string:}",
+		"{{literal}}",
+		"",
+	}
+	for _, s := range valid {
+		if _, err := ParseArgTemplate(s); err != nil {
+			t.Fatalf("error parsing %q: %s", s, err)
+		}
+	}
+
+	invalid := []string{
+		"{query}",
+		"{query.}",
+		"{cookie.session}",
+		"{query.limit:uint}",
+		"{query.limit:int:many}",
+		"{query.user",
+		"query.user}",
+	}
+	for _, s := range invalid {
+		if _, err := ParseArgTemplate(s); err == nil {
+			t.Fatalf("expected error parsing %q", s)
+		}
+	}
+}
+
+func TestArgTemplates(t *testing.T) {
+	h, err := New("./args.sh",
+		WithDir("."),
+		WithOutputHandler(EZOutputHandler),
+		WithRoot("/users/{id}"),
+		WithArgs("report"),
+		WithArgTemplates(
+			"--user={path.id}",
+			"--limit", "{query.limit:int:100}",
+			"{query.format:string:csv}",
+			"--verbose={query.verbose:bool:false}",
+			"{header.X-Tag:string:}",
+			"--role={json.role:string:none}",
+			"{{x}}",
+		),
+	)
+	if err != nil {
+		t.Fatalf("error while creating handler: %s", err)
+	}
+
+	type test struct {
+		Name           string
+		Method         string
+		Target         string
+		Header         http.Header
+		Body           string
+		ExpectedStatus int
+		ExpectedBody   string
+	}
+
+	tt := []test{
+		test{
+			Name:           "Defaults",
+			Target:         "/users/42",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   "[report]\n[--user=42]\n[--limit]\n[100]\n[csv]\n[--verbose=false]\n[]\n[--role=none]\n[{x}]\n\n",
+		},
+		test{
+			Name:           "Values",
+			Method:         "POST",
+			Target:         "/users/42?limit=07&format=json%20lines&verbose=1",
+			Header:         http.Header{"X-Tag": []string{"a b"}, "Content-Type": []string{"application/json"}},
+			Body:           `{"role": "admin"}`,
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   "[report]\n[--user=42]\n[--limit]\n[7]\n[json lines]\n[--verbose=true]\n[a b]\n[--role=admin]\n[{x}]\n{\"role\": \"admin\"}\n",
+		},
+		test{
+			Name:           "Invalid int",
+			Target:         "/users/42?limit=ten",
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		test{
+			Name:           "Option injection",
+			Target:         "/users/42?format=--delete",
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		test{
+			Name:           "Malformed JSON",
+			Method:         "POST",
+			Target:         "/users/42",
+			Header:         http.Header{"Content-Type": []string{"application/json"}},
+			Body:           `{"role": `,
+			ExpectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tt {
+		method := tc.Method
+		if method == "" {
+			method = "GET"
+		}
+		r := httptest.NewRequest(method, tc.Target, strings.NewReader(tc.Body))
+		for k, v := range tc.Header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != tc.ExpectedStatus {
+			t.Fatalf("%s: wrong status - expected: %d\treceived: %d", tc.Name, tc.ExpectedStatus, w.Code)
+		}
+		if tc.ExpectedBody != "" && w.Body.String() != tc.ExpectedBody {
+			t.Fatalf("%s: wrong body - expected: %q\treceived: %q", tc.Name, tc.ExpectedBody, w.Body.String())
+		}
+	}
+
+	// JSON bodies larger than DefaultMaxFormSize are only read if the Handler allows for larger request bodies.
+	body := `{"role": "admin", "padding": "` + strings.Repeat("x", DefaultMaxFormSize) + `"}`
+	for _, max := range []int64{0, 2 * DefaultMaxFormSize} {
+		h, err := New("./args.sh",
+			WithDir("."),
+			WithOutputHandler(EZOutputHandler),
+			WithMaxRequestBodySize(max),
+			WithArgTemplates("--role={json.role}"),
+		)
+		if err != nil {
+			t.Fatalf("error while creating handler: %s", err)
+		}
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		expected := http.StatusRequestEntityTooLarge
+		if max > 0 {
+			expected = http.StatusOK
+		}
+		if w.Code != expected {
+			t.Fatalf("JSON body with a %d bytes limit: wrong status - expected: %d\treceived: %d", max, expected, w.Code)
+		}
+		if expected == http.StatusOK && !strings.HasPrefix(w.Body.String(), "[--role=admin]\n") {
+			t.Fatalf("JSON body with a %d bytes limit: wrong body: %q", max, w.Body.String()[:32])
+		}
+	}
+
+	if _, err := New("./args.sh", WithDir("."), WithArgTemplates("{path.id}")); err == nil {
+		t.Fatal("expected error for path parameter missing from root")
+	}
+}
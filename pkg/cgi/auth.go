@@ -0,0 +1,55 @@
+package cgi
+
+import (
+	"context"
+	"net/http"
+)
+
+// Authenticator authenticates HTTP requests before the executable is run.
+type Authenticator interface {
+	// Authenticate returns the identity of the HTTP client that made the request r.
+	// If the request can't be authenticated, Authenticate responds to the HTTP client through w and returns nil;
+	// the executable is then never run.
+	Authenticate(w http.ResponseWriter, r *http.Request) *Identity
+}
+
+// loggingAuthenticator is implemented by the Authenticators that log, so that Handler can have them log with its own Logger.
+type loggingAuthenticator interface {
+	authenticate(w http.ResponseWriter, r *http.Request, logf logFunc) *Identity
+}
+
+// authenticate authenticates r with h.Auth, which logs with h's Logger if it logs at all.
+func (h *Handler) authenticate(w http.ResponseWriter, r *http.Request) *Identity {
+	if a, ok := h.Auth.(loggingAuthenticator); ok {
+		return a.authenticate(w, r, h.logErr)
+	}
+	return h.Auth.Authenticate(w, r)
+}
+
+// Identity is an authenticated HTTP client.
+type Identity struct {
+	// User is passed on to the executable in the REMOTE_USER environment variable.
+	User string
+	// AuthType is passed on to the executable in the AUTH_TYPE environment variable, e.g. "Basic".
+	AuthType string
+	// Env holds extra environment variables to pass on to the executable, in the form 'KEY=VALUE'.
+	Env []string
+}
+
+type identityKey struct{}
+
+// RequestIdentity returns the identity of the HTTP client that made the request r, or nil if it wasn't authenticated.
+func RequestIdentity(r *http.Request) *Identity {
+	id, _ := r.Context().Value(identityKey{}).(*Identity)
+	return id
+}
+
+func withIdentity(r *http.Request, id *Identity) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
+}
+
+// unauthorized responds to the HTTP client with a 401 along with the WWW-Authenticate challenge.
+func unauthorized(w http.ResponseWriter, challenge string) {
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
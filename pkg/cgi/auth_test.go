@@ -0,0 +1,287 @@
+package cgi
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHtpasswdAuth(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("bcrypt-pass"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("error while hashing password: %s", err)
+	}
+	dir, err := ioutil.TempDir("", "ez-cgi-test")
+	if err != nil {
+		t.Fatalf("error while creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	htpasswd := filepath.Join(dir, "htpasswd")
+	err = ioutil.WriteFile(htpasswd, []byte(
+		"# comment\n"+
+			"bcrypt:"+string(bcryptHash)+"\n"+
+			"sha:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"+
+			"apr1:$apr1$saltsalt$yAAkm4libquA.ZWLHbSBq/\n"+
+			"plain:password\n",
+	), 0600)
+	if err != nil {
+		t.Fatalf("error while writing htpasswd file: %s", err)
+	}
+
+	auth, err := NewHtpasswdAuth(htpasswd, "test")
+	if err != nil {
+		t.Fatalf("error while loading htpasswd file: %s", err)
+	}
+	var logged bytes.Buffer
+	h := &Handler{
+		Path:          "./remoteuser.sh",
+		Dir:           ".",
+		OutputHandler: EZOutputHandler,
+		Auth:          auth,
+		Logger:        log.New(&logged, "", 0),
+	}
+
+	type test struct {
+		Name           string
+		User           string
+		Password       string
+		ExpectedStatus int
+	}
+
+	tt := []test{
+		test{Name: "No credentials", ExpectedStatus: http.StatusUnauthorized},
+		test{Name: "Unknown user", User: "nobody", Password: "password", ExpectedStatus: http.StatusUnauthorized},
+		test{Name: "Wrong password", User: "sha", Password: "wrong", ExpectedStatus: http.StatusUnauthorized},
+		test{Name: "bcrypt", User: "bcrypt", Password: "bcrypt-pass", ExpectedStatus: http.StatusOK},
+		test{Name: "SHA", User: "sha", Password: "password", ExpectedStatus: http.StatusOK},
+		test{Name: "apr1", User: "apr1", Password: "password", ExpectedStatus: http.StatusOK},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tc.User != "" {
+				r.SetBasicAuth(tc.User, tc.Password)
+			}
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+
+			result := w.Result()
+			if result.StatusCode != tc.ExpectedStatus {
+				t.Fatalf("wrong status - expected: %d\treceived: %d", tc.ExpectedStatus, result.StatusCode)
+			}
+			if tc.ExpectedStatus != http.StatusOK {
+				if result.Header.Get("WWW-Authenticate") != `Basic realm="test"` {
+					t.Fatalf("wrong challenge: %s", result.Header.Get("WWW-Authenticate"))
+				}
+				return
+			}
+			body, _ := ioutil.ReadAll(result.Body)
+			if expected := "Basic " + tc.User + "\n"; string(body) != expected {
+				t.Fatalf("wrong body - expected: %q\treceived: %q", expected, body)
+			}
+		})
+	}
+
+	// Problems with the file go to the Handler's Logger, once.
+	if n := strings.Count(logged.String(), `unsupported hash for user "plain"`); n != 1 {
+		t.Fatalf("unsupported hash logged %d times: %q", n, logged.String())
+	}
+
+	// Replace the file and make sure the change is picked up.
+	err = ioutil.WriteFile(htpasswd, []byte("new:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"), 0600)
+	if err != nil {
+		t.Fatalf("error while writing htpasswd file: %s", err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(htpasswd, future, future)
+	auth.file.checked = time.Time{}
+
+	for user, expected := range map[string]int{"sha": http.StatusUnauthorized, "new": http.StatusOK} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.SetBasicAuth(user, "password")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != expected {
+			t.Fatalf("wrong status after reload for %s - expected: %d\treceived: %d", user, expected, w.Code)
+		}
+	}
+
+	// A malformed version of the file is only logged about once, the previous version is kept.
+	err = ioutil.WriteFile(htpasswd, []byte("malformed\n"), 0600)
+	if err != nil {
+		t.Fatalf("error while writing htpasswd file: %s", err)
+	}
+	future = future.Add(time.Minute)
+	os.Chtimes(htpasswd, future, future)
+	logged.Reset()
+	for i := 0; i < 3; i++ {
+		auth.file.checked = time.Time{}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.SetBasicAuth("new", "password")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("wrong status after malformed reload - expected: %d\treceived: %d", http.StatusOK, w.Code)
+		}
+	}
+	if n := strings.Count(logged.String(), "error reloading"); n != 1 {
+		t.Fatalf("malformed file logged %d times: %q", n, logged.String())
+	}
+}
+
+// signJWT returns a JWT with the given claims signed by key using alg.
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	hasher := crypto.SHA256.New()
+	hasher.Write([]byte(signed))
+	digest := hasher.Sum(nil)
+
+	var sig []byte
+	var err error
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(crypto.SHA256.New, key)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest)
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key, digest)
+		sig = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32-len(rb):32], rb)
+		copy(sig[64-len(sb):], sb)
+	}
+	if err != nil {
+		t.Fatalf("error while signing JWT: %s", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTAuth(t *testing.T) {
+	secret := []byte("secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error while generating RSA key: %s", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error while generating ECDSA key: %s", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error while generating RSA key: %s", err)
+	}
+
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+		{"kty": "oct", "kid": "hmac", "k": b64(secret)},
+	}})
+	keys, err := ParseJWKS(jwks)
+	if err != nil {
+		t.Fatalf("error while parsing JWKS: %s", err)
+	}
+
+	h := &Handler{
+		Path:          "./jwtclaims.sh",
+		Dir:           ".",
+		OutputHandler: EZOutputHandler,
+		Auth: &JWTAuth{
+			Keys:     keys,
+			Audience: "ez-cgi",
+			Issuer:   "issuer",
+			Claims:   []string{"role", "groups"},
+			Realm:    "test",
+		},
+	}
+
+	now := time.Now().Unix()
+	valid := func(extra map[string]interface{}) map[string]interface{} {
+		claims := map[string]interface{}{
+			"sub":    "alice",
+			"aud":    []string{"other", "ez-cgi"},
+			"iss":    "issuer",
+			"exp":    now + 60,
+			"nbf":    now - 60,
+			"role":   "admin",
+			"groups": []string{"a", "b"},
+		}
+		for k, v := range extra {
+			claims[k] = v
+		}
+		return claims
+	}
+
+	type test struct {
+		Name           string
+		Token          string
+		ExpectedStatus int
+	}
+
+	tt := []test{
+		test{Name: "No token", ExpectedStatus: http.StatusUnauthorized},
+		test{Name: "Malformed", Token: "not.a.jwt", ExpectedStatus: http.StatusUnauthorized},
+		test{Name: "HS256", Token: signJWT(t, "HS256", "hmac", secret, valid(nil)), ExpectedStatus: http.StatusOK},
+		test{Name: "RS256", Token: signJWT(t, "RS256", "rsa", rsaKey, valid(nil)), ExpectedStatus: http.StatusOK},
+		test{Name: "ES256", Token: signJWT(t, "ES256", "ec", ecKey, valid(nil)), ExpectedStatus: http.StatusOK},
+		test{Name: "Unknown key", Token: signJWT(t, "RS256", "", otherKey, valid(nil)), ExpectedStatus: http.StatusUnauthorized},
+		test{Name: "Wrong algorithm", Token: signJWT(t, "HS256", "rsa", secret, valid(nil)), ExpectedStatus: http.StatusUnauthorized},
+		test{Name: "Expired", Token: signJWT(t, "HS256", "hmac", secret, valid(map[string]interface{}{"exp": now - 10})), ExpectedStatus: http.StatusUnauthorized},
+		test{Name: "Not yet valid", Token: signJWT(t, "HS256", "hmac", secret, valid(map[string]interface{}{"nbf": now + 60})), ExpectedStatus: http.StatusUnauthorized},
+		test{Name: "Wrong audience", Token: signJWT(t, "HS256", "hmac", secret, valid(map[string]interface{}{"aud": "other"})), ExpectedStatus: http.StatusUnauthorized},
+		test{Name: "Wrong issuer", Token: signJWT(t, "HS256", "hmac", secret, valid(map[string]interface{}{"iss": "other"})), ExpectedStatus: http.StatusUnauthorized},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tc.Token != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.Token)
+			}
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+
+			result := w.Result()
+			if result.StatusCode != tc.ExpectedStatus {
+				t.Fatalf("wrong status - expected: %d\treceived: %d", tc.ExpectedStatus, result.StatusCode)
+			}
+			if tc.ExpectedStatus != http.StatusOK {
+				if challenge := result.Header.Get("WWW-Authenticate"); !strings.HasPrefix(challenge, `Bearer realm="test"`) {
+					t.Fatalf("wrong challenge: %s", challenge)
+				}
+				return
+			}
+			body, _ := ioutil.ReadAll(result.Body)
+			if expected := "Bearer alice admin [\"a\",\"b\"]\n"; string(body) != expected {
+				t.Fatalf("wrong body - expected: %q\treceived: %q", expected, body)
+			}
+		})
+	}
+}
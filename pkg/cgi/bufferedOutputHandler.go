@@ -0,0 +1,142 @@
+package cgi
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// DefaultBufferMemoryLimit is the default number of bytes BufferedOutputHandler keeps in memory before spilling over to disk.
+const DefaultBufferMemoryLimit = 1 << 20
+
+// BufferedOutputHandler holds back the response until the client process has exited so that its exit code can decide the status code.
+// The response is generated by OutputHandler and buffered, once the client process exits:
+//
+// If it exited successfully the buffered response is sent, with its status code replaced by ExitStatus[0] if present.
+//
+// Otherwise the status code is looked up in ExitStatus, defaulting to 500.
+// Error status codes (400 and above) are sent with an error body in place of the buffered response,
+// any other status code is sent along with the buffered response.
+//
+// For example, the mapping {2: 400, 3: 404} would respond with a 400 if the client process exits with code 2,
+// a 404 if it exits with code 3 and a 500 for any other non-zero exit code.
+//
+// Trailers set by OutputHandler are sent after the buffered body, as they would have been without buffering.
+type BufferedOutputHandler struct {
+	// OutputHandler generates the response that's buffered.
+	// Defaults to DefaultOutputHandler.
+	OutputHandler OutputHandler
+	// ExitStatus maps the client process' exit codes to HTTP status codes.
+	ExitStatus map[int]int
+	// MemoryLimit is the number of bytes of the response body kept in memory, anything past that is spilled over to a temporary file.
+	// Defaults to DefaultBufferMemoryLimit.
+	MemoryLimit int64
+	// TempDir is the directory spilled over response bodies are written to.
+	// Defaults to the systems temporary directory.
+	TempDir string
+}
+
+func (bh *BufferedOutputHandler) HandleOutput(w http.ResponseWriter, r *http.Request, h *Handler, x *Execution) {
+	oh := bh.OutputHandler
+	if oh == nil {
+		oh = DefaultOutputHandler
+	}
+	limit := bh.MemoryLimit
+	if limit <= 0 {
+		limit = DefaultBufferMemoryLimit
+	}
+
+	rb := &responseBuffer{
+		header: make(http.Header),
+		body:   &spool{limit: limit, dir: bh.TempDir},
+	}
+	defer rb.body.Close()
+
+	oh.HandleOutput(rb, r, h, x)
+
+	// Whatever output wasn't read by the OutputHandler is discarded so the client process doesn't block on its way out.
+	io.Copy(ioutil.Discard, x.Stdout)
+	x.Wait()
+
+	if rb.err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		h.logErr("cgi: error buffering response: %v", rb.err)
+		return
+	}
+
+	code := x.ExitCode()
+	status, mapped := bh.ExitStatus[code]
+	if code != 0 {
+		h.logErr("cgi: client process exited with code %d", code)
+		if !mapped {
+			status = http.StatusInternalServerError
+		}
+		if status >= http.StatusBadRequest {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+	}
+	if status == 0 {
+		status = rb.status
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	// Trailer values set by OutputHandler end up in the buffered header along with everything else,
+	// they're held back until the body has been sent so that they're still sent as trailers.
+	trailer := make(http.Header)
+	for _, d := range rb.header["Trailer"] {
+		for _, k := range strings.Split(d, ",") {
+			k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+			if vv, ok := rb.header[k]; ok {
+				trailer[k] = vv
+				delete(rb.header, k)
+			}
+		}
+	}
+
+	for k, vv := range rb.header {
+		w.Header()[k] = vv
+	}
+	w.WriteHeader(status)
+	if _, err := rb.body.WriteTo(w); err != nil {
+		h.logErr("cgi: copy error: %v", err)
+	}
+	for k, vv := range trailer {
+		w.Header()[k] = vv
+	}
+}
+
+// responseBuffer is an http.ResponseWriter that holds on to the response written to it.
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   *spool
+	err    error
+}
+
+func (rb *responseBuffer) Header() http.Header {
+	return rb.header
+}
+
+func (rb *responseBuffer) WriteHeader(status int) {
+	if rb.status == 0 {
+		rb.status = status
+	}
+}
+
+func (rb *responseBuffer) Write(p []byte) (int, error) {
+	if rb.status == 0 {
+		rb.WriteHeader(http.StatusOK)
+	}
+	n, err := rb.body.Write(p)
+	if err != nil && rb.err == nil {
+		rb.err = err
+	}
+	return n, err
+}
+
+// Flush is a no-op so that streaming OutputHandlers may still be buffered.
+func (rb *responseBuffer) Flush() {}
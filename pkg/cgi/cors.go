@@ -0,0 +1,152 @@
+package cgi
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsHeaders are the response headers CORS sets, the client CGI process isn't allowed to set them when CORS is enabled.
+var corsHeaders = []string{
+	"Access-Control-Allow-Origin",
+	"Access-Control-Allow-Credentials",
+	"Access-Control-Allow-Methods",
+	"Access-Control-Allow-Headers",
+	"Access-Control-Expose-Headers",
+	"Access-Control-Max-Age",
+}
+
+// CORS configures Cross-Origin Resource Sharing.
+// Preflight requests are answered without running the executable,
+// while the CORS headers of other requests are set before the client CGI process' headers are merged in.
+type CORS struct {
+	// AllowedOrigins are the origins allowed to make cross-origin requests, e.g. "https://example.com".
+	// An origin may contain a single '*' wildcard, e.g. "https://*.example.com", and "*" allows any origin.
+	AllowedOrigins []string
+	// AllowedMethods are the methods allowed in cross-origin requests.
+	// Defaults to GET, HEAD and POST.
+	AllowedMethods []string
+	// AllowedHeaders are the request headers allowed in cross-origin requests, "*" allows any header.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers browsers let cross-origin requests read.
+	ExposedHeaders []string
+	// AllowCredentials lets cross-origin requests include cookies and HTTP authentication.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache the answer to a preflight request.
+	// Zero leaves it up to the browser.
+	MaxAge time.Duration
+}
+
+// serve sets the CORS response headers for the request r.
+// It returns true if r was a preflight request, which has then already been responded to.
+func (c *CORS) serve(w http.ResponseWriter, r *http.Request) bool {
+	header := w.Header()
+	header.Add("Vary", "Origin")
+
+	origin := r.Header.Get("Origin")
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if preflight {
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+	}
+	if origin == "" {
+		return false
+	}
+
+	allowOrigin, ok := c.allowOrigin(origin)
+	if !ok {
+		if preflight {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		}
+		return preflight
+	}
+	setAllowed := func() {
+		header.Set("Access-Control-Allow-Origin", allowOrigin)
+		if c.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+
+	if !preflight {
+		setAllowed()
+		if len(c.ExposedHeaders) > 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+		}
+		return false
+	}
+
+	methods := c.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+	if !containsFold(methods, r.Header.Get("Access-Control-Request-Method")) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return true
+	}
+
+	var requested []string
+	for _, v := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, k := range strings.Split(v, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				requested = append(requested, k)
+			}
+		}
+	}
+	allowedHeaders := c.AllowedHeaders
+	if containsFold(allowedHeaders, "*") {
+		allowedHeaders = requested
+	}
+	for _, k := range requested {
+		if !containsFold(allowedHeaders, k) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return true
+		}
+	}
+
+	setAllowed()
+	header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(allowedHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(allowedHeaders, ", "))
+	}
+	if c.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// allowOrigin returns the value of Access-Control-Allow-Origin for origin, if it's allowed.
+func (c *CORS) allowOrigin(origin string) (string, bool) {
+	lower := strings.ToLower(origin)
+	for _, pattern := range c.AllowedOrigins {
+		pattern = strings.ToLower(pattern)
+		if pattern == "*" {
+			// Browsers refuse a wildcard along with credentials, the origin has to be echoed back instead.
+			if c.AllowCredentials {
+				return origin, true
+			}
+			return "*", true
+		}
+		if i := strings.Index(pattern, "*"); i >= 0 {
+			prefix, suffix := pattern[:i], pattern[i+1:]
+			if len(lower) > len(prefix)+len(suffix) && strings.HasPrefix(lower, prefix) && strings.HasSuffix(lower, suffix) {
+				return origin, true
+			}
+			continue
+		}
+		if pattern == lower {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
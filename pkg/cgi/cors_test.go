@@ -0,0 +1,152 @@
+package cgi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	type test struct {
+		Name           string
+		Method         string
+		Header         http.Header
+		ExpectedStatus int
+		ExpectedHeader http.Header
+		ExpectedBody   string
+	}
+
+	tt := []test{
+		test{
+			Name:           "Same origin",
+			Method:         "GET",
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{
+				"Access-Control-Allow-Origin": nil,
+				"Vary":                        []string{"Origin", "Accept"},
+			},
+			ExpectedBody: "PASS\n",
+		},
+		test{
+			Name:           "Allowed origin",
+			Method:         "GET",
+			Header:         http.Header{"Origin": []string{"https://app.example.com"}},
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{
+				"Access-Control-Allow-Origin":      []string{"https://app.example.com"},
+				"Access-Control-Allow-Credentials": []string{"true"},
+				"Access-Control-Expose-Headers":    []string{"X-Rows"},
+				"Vary":                             []string{"Origin", "Accept"},
+			},
+			ExpectedBody: "PASS\n",
+		},
+		test{
+			Name:           "Wildcard origin",
+			Method:         "POST",
+			Header:         http.Header{"Origin": []string{"https://tools.example.org"}},
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{
+				"Access-Control-Allow-Origin": []string{"https://tools.example.org"},
+			},
+			ExpectedBody: "PASS\n",
+		},
+		test{
+			Name:           "Disallowed origin",
+			Method:         "GET",
+			Header:         http.Header{"Origin": []string{"https://evil.example.com"}},
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{
+				"Access-Control-Allow-Origin": nil,
+			},
+			ExpectedBody: "PASS\n",
+		},
+		test{
+			Name:   "Preflight",
+			Method: "OPTIONS",
+			Header: http.Header{
+				"Origin":                         []string{"https://app.example.com"},
+				"Access-Control-Request-Method":  []string{"POST"},
+				"Access-Control-Request-Headers": []string{"x-api-key, content-type"},
+			},
+			ExpectedStatus: http.StatusNoContent,
+			ExpectedHeader: http.Header{
+				"Access-Control-Allow-Origin":  []string{"https://app.example.com"},
+				"Access-Control-Allow-Methods": []string{"GET, POST"},
+				"Access-Control-Allow-Headers": []string{"Content-Type, X-Api-Key"},
+				"Access-Control-Max-Age":       []string{"3600"},
+			},
+		},
+		test{
+			Name:   "Preflight disallowed method",
+			Method: "OPTIONS",
+			Header: http.Header{
+				"Origin":                        []string{"https://app.example.com"},
+				"Access-Control-Request-Method": []string{"DELETE"},
+			},
+			ExpectedStatus: http.StatusForbidden,
+			ExpectedHeader: http.Header{
+				"Access-Control-Allow-Origin": nil,
+			},
+		},
+		test{
+			Name:   "Preflight disallowed header",
+			Method: "OPTIONS",
+			Header: http.Header{
+				"Origin":                         []string{"https://app.example.com"},
+				"Access-Control-Request-Method":  []string{"GET"},
+				"Access-Control-Request-Headers": []string{"X-Secret"},
+			},
+			ExpectedStatus: http.StatusForbidden,
+		},
+	}
+
+	// The CORS headers written by cors.sh must be ignored whichever output handler reads them.
+	outputHandlers := map[string]OutputHandler{
+		"Replacer": EZOutputHandlerReplacer,
+		"Default":  DefaultOutputHandler,
+	}
+	for name, oh := range outputHandlers {
+		h, err := New("./cors.sh",
+			WithDir("."),
+			WithOutputHandler(oh),
+			WithCORS(&CORS{
+				AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+				AllowedMethods:   []string{"GET", "POST"},
+				AllowedHeaders:   []string{"Content-Type", "X-Api-Key"},
+				ExposedHeaders:   []string{"X-Rows"},
+				AllowCredentials: true,
+				MaxAge:           time.Hour,
+			}),
+		)
+		if err != nil {
+			t.Fatalf("error while creating handler: %s", err)
+		}
+
+		for _, tc := range tt {
+			t.Run(name+"/"+tc.Name, func(t *testing.T) {
+				r := httptest.NewRequest(tc.Method, "/", nil)
+				for k, vv := range tc.Header {
+					r.Header[k] = vv
+				}
+				w := httptest.NewRecorder()
+
+				h.ServeHTTP(w, r)
+
+				result := w.Result()
+				if result.StatusCode != tc.ExpectedStatus {
+					t.Fatalf("wrong status - expected: %d\treceived: %d", tc.ExpectedStatus, result.StatusCode)
+				}
+				for k, vv := range tc.ExpectedHeader {
+					if !reflect.DeepEqual(result.Header[k], vv) {
+						t.Fatalf("wrong header: %s - expected: %v\treceived: %v", k, vv, result.Header[k])
+					}
+				}
+				if tc.ExpectedBody != "" && w.Body.String() != tc.ExpectedBody {
+					t.Fatalf("wrong body - expected: %q\treceived: %q", tc.ExpectedBody, w.Body.String())
+				}
+			})
+		}
+	}
+}
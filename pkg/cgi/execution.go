@@ -0,0 +1,275 @@
+package cgi
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxCapturedStderr is the maximum number of bytes of the client process' stderr kept around by an Execution.
+const MaxCapturedStderr = 64 << 10
+
+// exitGrace is how long stderr and the trailer file descriptor are waited on once the client process has exited.
+// Processes it started in the background inherit them and may hold on to them for much longer, or forever.
+const exitGrace = 100 * time.Millisecond
+
+// Execution is a running instance of a Handler's executable.
+// It is handed to the OutputHandler along with the HTTP request.
+type Execution struct {
+	// Stdout is the client process' standard output.
+	Stdout io.Reader
+	// Pid is the client process' process id.
+	Pid int
+	// Start is when the client process was started.
+	Start time.Time
+	// Env is the environment the client process was started with.
+	Env []string
+
+	process *os.Process
+	stdout  *os.File
+	stderr  *cappedBuffer
+
+	// trailer holds what the client process wrote to its trailer file descriptor, it's complete once trailerDone is closed.
+	trailer     *cappedBuffer
+	trailerDone chan struct{}
+
+	// running is where the execution is kept track of while it's in flight, nil if it isn't.
+	running *running
+
+	done  chan struct{}
+	end   time.Time
+	state *os.ProcessState
+	err   error
+}
+
+// start starts the executable with the environment env reading its standard input from stdin, which may be nil.
+// If stdin is an *os.File it is handed directly to the client process and it's up to the caller to close it once start returns.
+func (h *Handler) start(env []string, stdin io.Reader) (*Execution, error) {
+	cmd := h.command(env)
+	cmd.Stdin = stdin
+
+	// Stdout is piped manually rather than through cmd.StdoutPipe so that waiting on the process doesn't close it.
+	stdoutRead, stdoutWrite, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = stdoutWrite
+
+	// So is stderr, otherwise waiting on the process would also wait on any background process that inherited it.
+	stderrRead, stderrWrite, err := os.Pipe()
+	if err != nil {
+		stdoutRead.Close()
+		stdoutWrite.Close()
+		return nil, err
+	}
+	cmd.Stderr = stderrWrite
+
+	x := &Execution{
+		Stdout: stdoutRead,
+		Env:    env,
+		stdout: stdoutRead,
+		stderr: &cappedBuffer{max: MaxCapturedStderr},
+		done:   make(chan struct{}),
+	}
+
+	var trailerRead, trailerWrite *os.File
+	if h.Trailers {
+		trailerRead, trailerWrite, err = os.Pipe()
+		if err != nil {
+			stdoutRead.Close()
+			stdoutWrite.Close()
+			stderrRead.Close()
+			stderrWrite.Close()
+			return nil, err
+		}
+		// The first extra file is always file descriptor 3 in the client process, see TrailerFD.
+		cmd.ExtraFiles = []*os.File{trailerWrite}
+	}
+
+	err = cmd.Start()
+	stdoutWrite.Close()
+	stderrWrite.Close()
+	if trailerWrite != nil {
+		trailerWrite.Close()
+	}
+	if err != nil {
+		stdoutRead.Close()
+		stderrRead.Close()
+		if trailerRead != nil {
+			trailerRead.Close()
+		}
+		return nil, err
+	}
+	x.Start = time.Now()
+	x.Pid = cmd.Process.Pid
+	x.process = cmd.Process
+	if h.running != nil {
+		x.running = h.running
+		x.running.add(x)
+	}
+
+	if trailerRead != nil {
+		max := h.MaxHeaderSize
+		if max <= 0 {
+			max = DefaultMaxHeaderSize
+		}
+		x.trailer = &cappedBuffer{max: max}
+		x.trailerDone = make(chan struct{})
+		go func() {
+			io.Copy(x.trailer, trailerRead)
+			trailerRead.Close()
+			close(x.trailerDone)
+		}()
+	}
+
+	stderrDone := make(chan struct{})
+	go func() {
+		io.Copy(io.MultiWriter(h.Stderr, x.stderr), stderrRead)
+		stderrRead.Close()
+		close(stderrDone)
+	}()
+
+	go func() {
+		x.err = cmd.Wait()
+		x.state = cmd.ProcessState
+		x.end = time.Now()
+		select {
+		case <-stderrDone:
+		case <-time.After(exitGrace):
+		}
+		close(x.done)
+	}()
+
+	return x, nil
+}
+
+// Wait waits for the client process to exit and returns its state along with any error encountered while waiting,
+// an error is also returned if the client process didn't exit successfully.
+// Wait may be called any number of times, from any goroutine.
+//
+// The client process may block while writing to its stdout if nobody is reading it,
+// Stdout should usually be read to completion before calling Wait.
+func (x *Execution) Wait() (*os.ProcessState, error) {
+	<-x.done
+	return x.state, x.err
+}
+
+// Exited reports whether the client process has exited, without waiting for it.
+func (x *Execution) Exited() bool {
+	select {
+	case <-x.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// ExitCode waits for the client process to exit and returns its exit code.
+// Returns -1 if the client process was terminated by a signal.
+func (x *Execution) ExitCode() int {
+	state, _ := x.Wait()
+	if state == nil {
+		return -1
+	}
+	return state.ExitCode()
+}
+
+// Duration returns how long the client process has been running for, or how long it ran for if it has exited.
+func (x *Execution) Duration() time.Duration {
+	if x.Exited() {
+		return x.end.Sub(x.Start)
+	}
+	return time.Since(x.Start)
+}
+
+// Stderr returns the first MaxCapturedStderr bytes the client process has written to its stderr so far.
+// Everything written to stderr is still forwarded to the Handler's Stderr.
+// Call Wait first to make sure all of stderr has been captured.
+func (x *Execution) Stderr() []byte {
+	return x.stderr.Bytes()
+}
+
+// Trailer returns the trailer values the client process wrote to file descriptor TrailerFD, one 'KEY: VALUE' per line.
+// Trailer blocks until the client process closes the file descriptor or exits,
+// so Stdout should be read to completion before calling Trailer.
+// Returns nil if the Handler doesn't have Trailers enabled.
+func (x *Execution) Trailer() http.Header {
+	if x.trailerDone == nil {
+		return nil
+	}
+	select {
+	case <-x.trailerDone:
+	case <-x.done:
+		// Whatever the client process wrote before exiting is given a moment to be read.
+		select {
+		case <-x.trailerDone:
+		case <-time.After(exitGrace):
+		}
+	}
+
+	trailer := make(http.Header)
+	scanner := bufio.NewScanner(bytes.NewReader(x.trailer.Bytes()))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) < 2 {
+			continue
+		}
+		trailer.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	return trailer
+}
+
+// Kill causes the client process to exit immediately.
+func (x *Execution) Kill() error {
+	return x.Signal(os.Kill)
+}
+
+// Signal sends sig to the client process.
+// Signaling a client process that has already exited does nothing.
+func (x *Execution) Signal(sig os.Signal) error {
+	if x.Exited() {
+		return nil
+	}
+	return x.process.Signal(sig)
+}
+
+// close makes sure the client process is good and dead and releases its resources.
+func (x *Execution) close() {
+	x.Kill()
+	x.stdout.Close()
+	<-x.done
+	if x.running != nil {
+		x.running.remove(x)
+	}
+}
+
+// cappedBuffer is a concurrency safe buffer that silently discards anything written past its first max bytes.
+type cappedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	max int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.max - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *cappedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
@@ -0,0 +1,191 @@
+package cgi
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// DefaultMaxFormSize is the default maximum size in bytes of the form bodies parsed by FormEnv.
+// Everything parsed ends up in the client process' environment, which most systems limit to a couple megabytes in total.
+const DefaultMaxFormSize = 64 << 10
+
+// MultiValuePolicy decides how FormEnv passes on parameters that are given more than once.
+type MultiValuePolicy int
+
+const (
+	// MultiValueJoin passes on every value of the parameter joined with FormEnv.Separator.
+	MultiValueJoin MultiValuePolicy = iota
+	// MultiValueFirst passes on the first value of the parameter.
+	MultiValueFirst
+	// MultiValueLast passes on the last value of the parameter.
+	MultiValueLast
+	// MultiValueIndexed passes on each value of the parameter in its own variable suffixed with its index, starting at 0,
+	// along with the number of values in a variable suffixed with _COUNT, e.g. QUERY_TAG_0, QUERY_TAG_1 and QUERY_TAG_COUNT.
+	MultiValueIndexed
+)
+
+// ParseMultiValuePolicy parses the name of a MultiValuePolicy: "join", "first", "last" or "indexed".
+func ParseMultiValuePolicy(s string) (MultiValuePolicy, error) {
+	switch strings.ToLower(s) {
+	case "join":
+		return MultiValueJoin, nil
+	case "first":
+		return MultiValueFirst, nil
+	case "last":
+		return MultiValueLast, nil
+	case "indexed":
+		return MultiValueIndexed, nil
+	}
+	return 0, fmt.Errorf("cgi: invalid multi-value policy: %q: must be one of 'join', 'first', 'last' or 'indexed'", s)
+}
+
+// FormEnv passes on the parameters of requests to the client process in environment variables,
+// sparing it from decoding QUERY_STRING or its request body itself.
+// Parameter names are upper cased with anything but letters and digits replaced by underscores: "user-id" becomes QUERY_USER_ID.
+// Parameters never override the other environment variables of the client process, such as QUERY_STRING.
+//
+// Requests with malformed or oversized parameters are responded to with a 400, or a 413 if the form body is too large,
+// before the client process is started.
+type FormEnv struct {
+	// Query passes on the parameters in the request's query string, each in a QUERY_<NAME> environment variable.
+	Query bool
+	// Form passes on the parameters in application/x-www-form-urlencoded request bodies, each in a FORM_<NAME> environment variable.
+	// The request body is still written to the client process' stdin.
+	Form bool
+
+	// MultiValue decides how parameters that are given more than once are passed on.
+	// Defaults to MultiValueJoin.
+	MultiValue MultiValuePolicy
+	// Separator joins the values of parameters given more than once when MultiValue is MultiValueJoin.
+	// Defaults to ",".
+	Separator string
+
+	// MaxFormSize is the maximum size in bytes of the form bodies that are parsed.
+	// Defaults to DefaultMaxFormSize.
+	MaxFormSize int64
+	// MaxValueSize, if positive, is the maximum size in bytes of a single parameter value.
+	MaxValueSize int
+	// MaxParams, if positive, is the maximum number of distinct parameters a request may have, query and form combined.
+	MaxParams int
+}
+
+// env returns the environment variables for the parameters of r.
+// If r should be rejected, the error is returned along with the status code to respond with.
+// r's body is read when it's a form, and replaced with a copy of itself.
+func (f *FormEnv) env(r *http.Request) ([]string, int, error) {
+	var env []string
+	params := 0
+	if f.Query {
+		query, err := url.ParseQuery(r.URL.RawQuery)
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("malformed query string: %v", err)
+		}
+		vars, err := f.vars("QUERY_", query)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		env = append(env, vars...)
+		params += len(query)
+	}
+
+	if f.Form && r.Body != nil && r.ContentLength != 0 {
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "application/x-www-form-urlencoded" {
+			max := f.MaxFormSize
+			if max <= 0 {
+				max = DefaultMaxFormSize
+			}
+			if r.ContentLength > max {
+				return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("form body larger than %d bytes", max)
+			}
+			body, err := ioutil.ReadAll(io.LimitReader(r.Body, max+1))
+			if err != nil {
+				return nil, http.StatusBadRequest, fmt.Errorf("error reading form body: %v", err)
+			}
+			if int64(len(body)) > max {
+				return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("form body larger than %d bytes", max)
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			form, err := url.ParseQuery(string(body))
+			if err != nil {
+				return nil, http.StatusBadRequest, fmt.Errorf("malformed form body: %v", err)
+			}
+			vars, err := f.vars("FORM_", form)
+			if err != nil {
+				return nil, http.StatusBadRequest, err
+			}
+			env = append(env, vars...)
+			params += len(form)
+		}
+	}
+
+	if f.MaxParams > 0 && params > f.MaxParams {
+		return nil, http.StatusBadRequest, fmt.Errorf("more than %d parameters", f.MaxParams)
+	}
+	return env, 0, nil
+}
+
+// vars turns values into environment variables whose names start with prefix.
+func (f *FormEnv) vars(prefix string, values url.Values) ([]string, error) {
+	// Names that only differ by the characters envVarName replaces end up in the same variable,
+	// their values are merged in the order of their names so that the result doesn't depend on map iteration order.
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	merged := make(map[string][]string)
+	for _, k := range keys {
+		vs := values[k]
+		if k == "" {
+			continue
+		}
+		for _, v := range vs {
+			if f.MaxValueSize > 0 && len(v) > f.MaxValueSize {
+				return nil, fmt.Errorf("value of parameter %q larger than %d bytes", k, f.MaxValueSize)
+			}
+			if strings.IndexByte(v, 0) != -1 {
+				return nil, fmt.Errorf("value of parameter %q contains a NUL byte", k)
+			}
+		}
+		name := prefix + envVarName(k)
+		merged[name] = append(merged[name], vs...)
+	}
+
+	names := make([]string, 0, len(merged))
+	for name := range merged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var env []string
+	for _, name := range names {
+		vs := merged[name]
+		switch f.MultiValue {
+		case MultiValueFirst:
+			env = append(env, name+"="+vs[0])
+		case MultiValueLast:
+			env = append(env, name+"="+vs[len(vs)-1])
+		case MultiValueIndexed:
+			for i, v := range vs {
+				env = append(env, fmt.Sprintf("%s_%d=%s", name, i, v))
+			}
+			env = append(env, fmt.Sprintf("%s_COUNT=%d", name, len(vs)))
+		default:
+			sep := f.Separator
+			if sep == "" {
+				sep = ","
+			}
+			env = append(env, name+"="+strings.Join(vs, sep))
+		}
+	}
+	return env, nil
+}
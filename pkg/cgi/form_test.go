@@ -0,0 +1,166 @@
+package cgi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormEnv(t *testing.T) {
+	h, err := New("./formenv.sh",
+		WithDir("."),
+		WithOutputHandler(EZOutputHandler),
+		WithFormEnv(&FormEnv{
+			Query:        true,
+			Form:         true,
+			MaxFormSize:  64,
+			MaxValueSize: 16,
+			MaxParams:    4,
+		}),
+	)
+	if err != nil {
+		t.Fatalf("error while creating handler: %s", err)
+	}
+
+	type test struct {
+		Name           string
+		Method         string
+		Target         string
+		ContentType    string
+		Body           string
+		ExpectedStatus int
+		ExpectedBody   string
+	}
+
+	tt := []test{
+		test{
+			Name:           "Query",
+			Target:         "/?user-id=42&tag=a&tag=b%20c",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   "user-id=42&tag=a&tag=b%20c|42|a,b c||\n",
+		},
+		test{
+			Name:           "Form",
+			Method:         "POST",
+			Target:         "/?tag=x",
+			ContentType:    "application/x-www-form-urlencoded; charset=utf-8",
+			Body:           "name=J%C3%BCrgen",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   "tag=x||x|Jürgen|name=J%C3%BCrgen\n",
+		},
+		test{
+			Name:           "Other bodies aren't parsed",
+			Method:         "POST",
+			ContentType:    "text/plain",
+			Body:           "name=x",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   "||||name=x\n",
+		},
+		test{
+			Name:           "Parameters can't override CGI variables",
+			Target:         "/?string=x",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   "string=x||||\n",
+		},
+		test{
+			Name:           "Malformed query",
+			Target:         "/?a=%zz",
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		test{
+			Name:           "NUL byte",
+			Target:         "/?a=%00",
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		test{
+			Name:           "Value too large",
+			Target:         "/?a=" + strings.Repeat("x", 17),
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		test{
+			Name:           "Too many parameters",
+			Target:         "/?a=1&b=2&c=3",
+			Method:         "POST",
+			ContentType:    "application/x-www-form-urlencoded",
+			Body:           "d=4&e=5",
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		test{
+			Name:           "Form too large",
+			Method:         "POST",
+			ContentType:    "application/x-www-form-urlencoded",
+			Body:           "a=" + strings.Repeat("x", 63),
+			ExpectedStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tc := range tt {
+		method := tc.Method
+		if method == "" {
+			method = "GET"
+		}
+		target := tc.Target
+		if target == "" {
+			target = "/"
+		}
+		r := httptest.NewRequest(method, target, strings.NewReader(tc.Body))
+		if tc.ContentType != "" {
+			r.Header.Set("Content-Type", tc.ContentType)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != tc.ExpectedStatus {
+			t.Fatalf("%s: wrong status - expected: %d\treceived: %d", tc.Name, tc.ExpectedStatus, w.Code)
+		}
+		if tc.ExpectedBody != "" && w.Body.String() != tc.ExpectedBody {
+			t.Fatalf("%s: wrong body - expected: %q\treceived: %q", tc.Name, tc.ExpectedBody, w.Body.String())
+		}
+	}
+}
+
+func TestFormEnvMultiValue(t *testing.T) {
+	values := url.Values{"tag": []string{"a", "b"}, "id": []string{"1"}}
+	expected := map[MultiValuePolicy][]string{
+		MultiValueJoin:    []string{"QUERY_ID=1", "QUERY_TAG=a|b"},
+		MultiValueFirst:   []string{"QUERY_ID=1", "QUERY_TAG=a"},
+		MultiValueLast:    []string{"QUERY_ID=1", "QUERY_TAG=b"},
+		MultiValueIndexed: []string{"QUERY_ID_0=1", "QUERY_ID_COUNT=1", "QUERY_TAG_0=a", "QUERY_TAG_1=b", "QUERY_TAG_COUNT=2"},
+	}
+	for policy, env := range expected {
+		f := &FormEnv{MultiValue: policy, Separator: "|"}
+		vars, err := f.vars("QUERY_", values)
+		if err != nil {
+			t.Fatalf("error with policy %d: %s", policy, err)
+		}
+		if !reflect.DeepEqual(vars, env) {
+			t.Fatalf("wrong variables with policy %d - expected: %v\treceived: %v", policy, env, vars)
+		}
+	}
+
+	// Parameters whose names collide are merged in the order of their names, every time.
+	colliding := url.Values{"a_b": []string{"2"}, "a-b": []string{"1"}, "A.B": []string{"0"}}
+	for i := 0; i < 20; i++ {
+		f := &FormEnv{MultiValue: MultiValueIndexed}
+		vars, err := f.vars("QUERY_", colliding)
+		if err != nil {
+			t.Fatalf("error with colliding names: %s", err)
+		}
+		env := []string{"QUERY_A_B_0=0", "QUERY_A_B_1=1", "QUERY_A_B_2=2", "QUERY_A_B_COUNT=3"}
+		if !reflect.DeepEqual(vars, env) {
+			t.Fatalf("wrong variables with colliding names - expected: %v\treceived: %v", env, vars)
+		}
+	}
+
+	for s, policy := range map[string]MultiValuePolicy{"join": MultiValueJoin, "First": MultiValueFirst, "last": MultiValueLast, "indexed": MultiValueIndexed} {
+		if p, err := ParseMultiValuePolicy(s); err != nil || p != policy {
+			t.Fatalf("wrong policy for %q - expected: %d\treceived: %d (%v)", s, policy, p, err)
+		}
+	}
+	if _, err := ParseMultiValuePolicy("all"); err == nil {
+		t.Fatal("expected error parsing invalid policy")
+	}
+}
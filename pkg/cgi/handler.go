@@ -1,7 +1,9 @@
 // Package cgi flexibly implements the CGI as specified in RFC 3875.
 // Allows for non-CGI conforming executables to be used and provides default headers.
 // The executable set to handle the HTTP requests is always provided with the environment variables described in
-// RFC 3875 Sections 4.1.2 - 4.1.5, 4.1.7 - 4.1.9, and 4.1.12 - 4.1.17.
+// RFC 3875 Sections 4.1.2 - 4.1.5, 4.1.7 - 4.1.9, and 4.1.12 - 4.1.17,
+// as well as Sections 4.1.1 and 4.1.11 when the request is authenticated,
+// and mod_ssl's SSL_* variables when the request is served over TLS.
 // The handling of the executables standard output is handled by a user provided function.
 // A lot of this code is copied straight from the Go standard library: https://golang.org/src/net/http/cgi/host.go
 package cgi
@@ -17,10 +19,14 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 )
 
 var portRegex = regexp.MustCompile(`:([0-9]+)$`)
 
+// TrailerFD is the file descriptor client CGI processes write their trailers to when Handler.Trailers is enabled.
+const TrailerFD = 3
+
 var osDefaultInheritEnv = map[string][]string{
 	"darwin":  {"DYLD_LIBRARY_PATH"},
 	"freebsd": {"LD_LIBRARY_PATH"},
@@ -37,7 +43,14 @@ var osDefaultInheritEnv = map[string][]string{
 type Handler struct {
 	Path string
 
+	// Root is the path prefix the Handler is mounted at, it's stripped from the request path to get PATH_INFO.
+	// Root may be a Pattern with named parameters, such as /users/{id}, in which case the value of each parameter is
+	// passed on to the executable in a CGI_PARAM_<NAME> environment variable and SCRIPT_NAME is the part of the path that matched Root.
 	Root string
+	// ArgTemplates are command line arguments built from each request, passed on to the executable after Args.
+	ArgTemplates []*ArgTemplate
+	// ParamArgs passes on the values of Root's parameters to the executable as command line arguments too, after ArgTemplates.
+	ParamArgs bool
 
 	Name string // value to use for SERVER_SOFTWARE env var
 	Port string
@@ -49,11 +62,90 @@ type Handler struct {
 	Args       []string
 	Stderr     io.Writer
 
+	// Env holds extra environment variables to pass on to the executable, in the form 'KEY=VALUE'.
+	Env []string
+
+	// Timeout is how long the client CGI process may run for handling a request before it's killed.
+	// Only the client CGI process itself is killed, not any processes it started.
+	// Zero means no limit. WebSocketHandler connections aren't subject to Timeout.
+	Timeout time.Duration
+
 	// Header contains header values that should be used by default.
-	// If the client CGI process writes a header to its stdout thats already in Header, it will be replaced.
+	// If the client CGI process writes a header to its stdout thats already in Header, it will be merged according to HeaderPolicy.
+	// Header is never modified by Handler.
 	Header http.Header
+	// HeaderPolicy decides how header values written by the client CGI process are merged with the values in Header.
+	// Defaults to HeaderOverride.
+	HeaderPolicy HeaderPolicy
+	// HeaderAllow, if not empty, lists the only headers the client CGI process is allowed to set.
+	HeaderAllow []string
+	// HeaderDeny lists headers the client CGI process is never allowed to set.
+	HeaderDeny []string
 	// OutputHandler takes care of responding the HTTP client based on the CGI client processes output.
+	// Defaults to DefaultOutputHandler.
 	OutputHandler OutputHandler
+
+	// MaxRequestBodySize is the maximum size in bytes of a request body, larger requests are responded to with a 413.
+	// Zero means no limit.
+	MaxRequestBodySize int64
+	// MaxHeaderSize is the maximum size in bytes of the header block written by the client CGI process.
+	// Defaults to DefaultMaxHeaderSize.
+	MaxHeaderSize int
+	// MaxResponseSize is the maximum number of bytes read from the client CGI process' stdout, headers included.
+	// Once it is reached the process is killed and the response is cut short.
+	// Zero means no limit.
+	MaxResponseSize int64
+	// AbortOversizedResponse aborts the connection to the HTTP client when MaxResponseSize is reached
+	// instead of truncating the response, so that the client can tell the response is incomplete.
+	AbortOversizedResponse bool
+
+	// Auth, if set, authenticates requests before the client CGI process is started.
+	// The authenticated user is passed on to the process in the AUTH_TYPE and REMOTE_USER environment variables,
+	// the Authorization header it was authenticated with isn't passed on in HTTP_AUTHORIZATION.
+	Auth Authenticator
+
+	// Methods, if not empty, lists the only request methods the client CGI process is run for,
+	// requests with other methods are responded to with a 405.
+	// HEAD requests are allowed whenever GET is, as net/http treats HEAD as GET.
+	Methods []string
+
+	// CORS, if set, enables Cross-Origin Resource Sharing.
+	// The CORS headers are set by Handler, the client CGI process isn't allowed to set them.
+	CORS *CORS
+
+	// RateLimit, if set, limits how often each HTTP client can have the client CGI process run.
+	// Limited requests are responded to with a 429 before the process is started.
+	// Requests are counted before they're authenticated, so failed authentication attempts are limited too,
+	// unless clients are limited by user, which is only known once they're authenticated.
+	RateLimit *RateLimiter
+
+	// IPFilter, if set, decides which HTTP clients are allowed based on their IP address.
+	// Denied requests are responded to with a 403 before the client CGI process is started.
+	IPFilter *IPFilter
+	// TrustedProxies are the networks of reverse proxies whose X-Forwarded-For header is trusted to tell the HTTP client's address.
+	// The address is used for REMOTE_ADDR as well as by IPFilter.
+	TrustedProxies []*net.IPNet
+
+	// FormEnv, if set, passes on the query and form parameters of requests to the client CGI process in environment variables.
+	FormEnv *FormEnv
+
+	// Uploads, if set, parses multipart/form-data request bodies and spools the uploaded files for the client CGI process.
+	Uploads *Uploads
+
+	// Trailers gives the client CGI process a side channel, file descriptor TrailerFD, to write out HTTP trailers on.
+	// Trailers declared by the process in its "Trailer" header are sent with the values written to TrailerFD once the body has been sent.
+	// The process is told which file descriptor to use through the CGI_TRAILER_FD environment variable.
+	// Trailers aren't supported on Windows.
+	Trailers bool
+
+	// sealed is the private copy of the configuration used to serve requests by Handlers created with New.
+	sealed *Handler
+	// pattern is Root parsed by New, nil if Root doesn't have any parameters.
+	pattern *Pattern
+	// running keeps track of the executions in flight for Handlers created with New, it's shared by all their copies.
+	running *running
+	// staticEnv holds the environment variables that don't depend on the request, precomputed by New.
+	staticEnv []string
 }
 
 func (h *Handler) logErr(format string, v ...interface{}) {
@@ -65,32 +157,230 @@ func (h *Handler) logErr(format string, v ...interface{}) {
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if h.Root == "" {
-		h.Root = "/"
+	h = h.config()
+
+	if len(r.TransferEncoding) > 0 && r.TransferEncoding[0] == "chunked" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Chunked request bodies are not supported by CGI."))
+		return
 	}
-	if h.Name == "" {
-		h.Name = "go"
+
+	internalError := func(err error) {
+		w.WriteHeader(http.StatusInternalServerError)
+		h.logErr("CGI error: %v", err)
 	}
-	if h.Stderr == nil {
-		h.Stderr = os.Stderr
+
+	if !h.allowIP(w, r) {
+		return
 	}
-	if h.Header == nil {
-		h.Header = http.Header{
-			"Content-Type": []string{"text/plain"},
+
+	if h.CORS != nil {
+		if h.CORS.serve(w, r) {
+			return
 		}
+		h.HeaderDeny = append(h.HeaderDeny[:len(h.HeaderDeny):len(h.HeaderDeny)], corsHeaders...)
 	}
-	if h.OutputHandler == nil {
-		h.OutputHandler = DefaultOutputHandler
+
+	if len(h.Methods) > 0 && !h.allowsMethod(r.Method) {
+		w.Header().Set("Allow", strings.Join(h.allowedMethods(), ", "))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
 	}
 
-	if len(r.TransferEncoding) > 0 && r.TransferEncoding[0] == "chunked" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Chunked request bodies are not supported by CGI."))
+	r, ok := h.admit(w, r)
+	if !ok {
 		return
 	}
 
+	if h.MaxRequestBodySize > 0 {
+		if r.ContentLength > h.MaxRequestBodySize {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			h.logErr("cgi: request body too large: %d bytes", r.ContentLength)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, h.MaxRequestBodySize)
+	}
+
+	var uploadEnv []string
+	if h.Uploads != nil && isMultipartForm(r) {
+		up, code, err := h.Uploads.spool(r, h.FormEnv)
+		if err != nil {
+			http.Error(w, http.StatusText(code)+": "+err.Error(), code)
+			h.logErr("cgi: request rejected: %v", err)
+			return
+		}
+		// Deferred before the execution is closed so that the files are only removed once the client process has exited.
+		defer os.RemoveAll(up.dir)
+		uploadEnv = up.env
+
+		// The body has been consumed, the client process gets an empty stdin.
+		r = r.WithContext(r.Context())
+		r.Body = http.NoBody
+		r.ContentLength = 0
+	}
+
+	env, ok := h.requestEnv(w, r)
+	if !ok {
+		return
+	}
+	if uploadEnv != nil {
+		env = removeLeadingDuplicates(append(uploadEnv, env...))
+	}
+	if h.Args, ok = h.requestArgs(w, r); !ok {
+		return
+	}
+
+	var stdin io.Reader
+	if r.ContentLength != 0 {
+		stdin = r.Body
+	}
+	x, err := h.start(env, stdin)
+	if err != nil {
+		internalError(err)
+		return
+	}
+	// Make sure the process is good and dead before exiting
+	defer x.close()
+
+	if h.Timeout > 0 {
+		timer := time.AfterFunc(h.Timeout, func() {
+			h.logErr("cgi: client process ran for longer than %s, killing it", h.Timeout)
+			x.Kill()
+		})
+		defer timer.Stop()
+	}
+
+	var limiter *stdoutLimiter
+	if h.MaxResponseSize > 0 {
+		limiter = &stdoutLimiter{x: x, r: x.Stdout, remaining: h.MaxResponseSize}
+		x.Stdout = limiter
+	}
+
+	h.OutputHandler.HandleOutput(w, r, h, x)
+
+	if limiter != nil && limiter.wasExceeded() {
+		h.logErr("cgi: response larger than %d bytes, client process killed", h.MaxResponseSize)
+		if h.AbortOversizedResponse {
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// allowIP reports whether the IP rules let the HTTP client that made r through, responding with a 403 if they don't.
+// It's checked before anything else so denied clients aren't told anything about the route, not even its CORS policy or methods.
+func (h *Handler) allowIP(w http.ResponseWriter, r *http.Request) bool {
+	if h.IPFilter == nil {
+		return true
+	}
+	ip, _ := h.remoteAddr(r)
+	if !h.IPFilter.allowed(net.ParseIP(ip), h.logErr) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		h.logErr("cgi: request from %s denied by IP rules", ip)
+		return false
+	}
+	return true
+}
+
+// allowsMethod reports whether Methods lets requests with method through, HEAD being let through whenever GET is.
+func (h *Handler) allowsMethod(method string) bool {
+	if containsFold(h.Methods, method) {
+		return true
+	}
+	return strings.EqualFold(method, http.MethodHead) && containsFold(h.Methods, http.MethodGet)
+}
+
+// allowedMethods returns the methods to list in the Allow header of a 405 response.
+func (h *Handler) allowedMethods() []string {
+	if !containsFold(h.Methods, http.MethodGet) || containsFold(h.Methods, http.MethodHead) {
+		return h.Methods
+	}
+	methods := make([]string, 0, len(h.Methods)+1)
+	for _, m := range h.Methods {
+		methods = append(methods, m)
+		if strings.EqualFold(m, http.MethodGet) {
+			methods = append(methods, http.MethodHead)
+		}
+	}
+	return methods
+}
+
+// admit decides whether the request r, whose client has already been let through by allowIP, should be let through to the client CGI process.
+// If it shouldn't, the HTTP client has already been responded to.
+// The returned request carries along whatever admit learned about the HTTP client, such as its identity.
+func (h *Handler) admit(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	byUser := h.RateLimit != nil && h.RateLimit.Key == RateLimitByUser
+	if h.RateLimit != nil && !byUser && !h.takeToken(w, r) {
+		return r, false
+	}
+	if h.Auth != nil {
+		id := h.authenticate(w, r)
+		if id == nil {
+			return r, false
+		}
+		r = withIdentity(r, id)
+	}
+	if byUser && !h.takeToken(w, r) {
+		return r, false
+	}
+	return r, true
+}
+
+// takeToken takes a token from RateLimit for the HTTP client of r, responding with a 429 if there's none left.
+func (h *Handler) takeToken(w http.ResponseWriter, r *http.Request) bool {
+	if ok, wait := h.RateLimit.take(h.RateLimit.key(h, r), time.Now()); !ok {
+		tooManyRequests(w, wait)
+		return false
+	}
+	return true
+}
+
+// config returns the per-request copy of h that should be used to serve a request.
+func (h *Handler) config() *Handler {
+	if h.sealed != nil {
+		return h.sealed.withDefaults()
+	}
+	return h.withDefaults()
+}
+
+// withDefaults returns a per-request copy of h with its zero valued fields set to their default value.
+// Handler is shared across concurrent requests so it must never be modified while serving one.
+func (h *Handler) withDefaults() *Handler {
+	c := *h
+	if c.Root == "" {
+		c.Root = "/"
+	}
+	if c.Name == "" {
+		c.Name = "go"
+	}
+	if c.Stderr == nil {
+		c.Stderr = os.Stderr
+	}
+	if c.Header == nil {
+		c.Header = http.Header{
+			"Content-Type": []string{"text/plain"},
+		}
+	} else {
+		c.Header = c.Header.Clone()
+	}
+	if c.OutputHandler == nil {
+		c.OutputHandler = DefaultOutputHandler
+	}
+	return &c
+}
+
+// env returns the environment the executable should be run with in order to handle r.
+func (h *Handler) env(r *http.Request) []string {
 	pathInfo := r.URL.Path
-	if h.Root != "/" && strings.HasPrefix(pathInfo, h.Root) {
+	var paramEnv []string
+	if p := h.rootPattern(); p != nil {
+		if values, rest, ok := p.Match(pathInfo); ok {
+			paramEnv = append(paramEnv, "SCRIPT_NAME="+pathInfo[:len(pathInfo)-len(rest)])
+			for i, name := range p.params {
+				paramEnv = append(paramEnv, "CGI_PARAM_"+envVarName(name)+"="+values[i])
+			}
+			pathInfo = rest
+		}
+	} else if h.Root != "/" && strings.HasPrefix(pathInfo, h.Root) {
 		pathInfo = pathInfo[len(h.Root):]
 	}
 
@@ -103,28 +393,31 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	env := []string{
-		"SERVER_SOFTWARE=" + h.Name,
 		"SERVER_NAME=" + r.Host,
 		"SERVER_PROTOCOL=HTTP/1.1",
 		"HTTP_HOST=" + r.Host,
-		"GATEWAY_INTERFACE=CGI/1.1",
 		"REQUEST_METHOD=" + r.Method,
 		"QUERY_STRING=" + r.URL.RawQuery,
 		"REQUEST_URI=" + r.URL.RequestURI(),
 		"PATH_INFO=" + pathInfo,
-		"SCRIPT_NAME=" + h.Root,
-		"SCRIPT_FILENAME=" + h.Path,
 		"SERVER_PORT=" + port,
 	}
 
-	if remoteIP, remotePort, err := net.SplitHostPort(r.RemoteAddr); err == nil {
-		env = append(env, "REMOTE_ADDR="+remoteIP, "REMOTE_HOST="+remoteIP, "REMOTE_PORT="+remotePort)
-	} else {
-		env = append(env, "REMOTE_ADDR="+r.RemoteAddr, "REMOTE_HOST="+r.RemoteAddr)
+	remoteIP, remotePort := h.remoteAddr(r)
+	env = append(env, "REMOTE_ADDR="+remoteIP, "REMOTE_HOST="+remoteIP)
+	if remotePort != "" {
+		env = append(env, "REMOTE_PORT="+remotePort)
 	}
 
 	if r.TLS != nil {
 		env = append(env, "HTTPS=on")
+		env = append(env, tlsEnv(r.TLS)...)
+	}
+
+	id := RequestIdentity(r)
+	if id != nil {
+		env = append(env, "AUTH_TYPE="+id.AuthType, "REMOTE_USER="+id.User)
+		env = append(env, id.Env...)
 	}
 
 	for k, v := range r.Header {
@@ -132,6 +425,10 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if k == "PROXY" {
 			continue
 		}
+		// Credentials checked by Auth, such as passwords and tokens, are kept from the client process which gets the identity instead.
+		if k == "AUTHORIZATION" && id != nil && h.Auth != nil {
+			continue
+		}
 		joinStr := ", "
 		if k == "COOKIE" {
 			joinStr = "; "
@@ -146,20 +443,155 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		env = append(env, "CONTENT_TYPE="+ctype)
 	}
 
+	staticEnv := h.staticEnv
+	if staticEnv == nil {
+		staticEnv = h.buildStaticEnv()
+	}
+	env = append(env, staticEnv...)
+	env = append(env, paramEnv...)
+
+	return removeLeadingDuplicates(env)
+}
+
+// requestEnv returns the environment the executable should be run with in order to handle r, along with FormEnv's variables.
+// If FormEnv rejects r, the HTTP client has already been responded to.
+func (h *Handler) requestEnv(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	env := h.env(r)
+	if h.FormEnv == nil {
+		return env, true
+	}
+	vars, code, err := h.FormEnv.env(r)
+	if err != nil {
+		http.Error(w, http.StatusText(code)+": "+err.Error(), code)
+		h.logErr("cgi: request rejected: %v", err)
+		return nil, false
+	}
+	// The parameters go first so that they can't override any of the other variables.
+	return removeLeadingDuplicates(append(vars, env...)), true
+}
+
+// requestArgs returns the command line arguments the executable should be run with in order to handle r.
+// If r is rejected, the HTTP client has already been responded to.
+func (h *Handler) requestArgs(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	args, code, err := h.args(r)
+	if err != nil {
+		http.Error(w, http.StatusText(code)+": "+err.Error(), code)
+		h.logErr("cgi: request rejected: %v", err)
+		return nil, false
+	}
+	return args, true
+}
+
+// rootPattern returns Root as a Pattern, nil if it doesn't have any parameters.
+func (h *Handler) rootPattern() *Pattern {
+	if h.pattern != nil || !strings.Contains(h.Root, "{") {
+		return h.pattern
+	}
+	p, err := ParsePattern(h.Root)
+	if err != nil {
+		return nil
+	}
+	return p
+}
+
+// args returns the command line arguments the executable should be run with in order to handle r.
+// If ArgTemplates can't be built out of r, the error is returned along with the status code to respond with.
+func (h *Handler) args(r *http.Request) ([]string, int, error) {
+	args := h.Args[:len(h.Args):len(h.Args)]
+	if len(h.ArgTemplates) > 0 {
+		src, code, err := h.newArgSources(r, h.ArgTemplates)
+		if err != nil {
+			return nil, code, err
+		}
+		for _, t := range h.ArgTemplates {
+			arg, err := t.eval(src)
+			if err != nil {
+				return nil, http.StatusBadRequest, err
+			}
+			args = append(args, arg)
+		}
+	}
+
+	if h.ParamArgs {
+		if p := h.rootPattern(); p != nil {
+			values, _, _ := p.Match(r.URL.Path)
+			args = append(args, values...)
+		}
+	}
+	return args, 0, nil
+}
+
+// remoteAddr returns the IP address and port of the HTTP client that made the request r, port is empty if it's unknown.
+// Requests coming from one of TrustedProxies are attributed to the address the proxies recorded in X-Forwarded-For,
+// walking it back from the nearest proxy until an untrusted address is found.
+func (h *Handler) remoteAddr(r *http.Request) (string, string) {
+	ip, port, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip, port = r.RemoteAddr, ""
+	}
+	if !h.trustedProxy(ip) {
+		return ip, port
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip, port = hop, ""
+		if !h.trustedProxy(hop) {
+			break
+		}
+	}
+	return ip, port
+}
+
+func (h *Handler) trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range h.TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildStaticEnv returns the environment variables that don't depend on the request.
+func (h *Handler) buildStaticEnv() []string {
+	env := []string{
+		"SERVER_SOFTWARE=" + h.Name,
+		"GATEWAY_INTERFACE=CGI/1.1",
+		"SCRIPT_NAME=" + h.Root,
+		"SCRIPT_FILENAME=" + h.Path,
+	}
+
 	envPath := os.Getenv("PATH")
 	if envPath == "" {
 		envPath = "/bin:/usr/bin:/usr/ucb:/usr/bsd:/usr/local/bin"
 	}
 	env = append(env, "PATH="+envPath)
 
+	if h.Trailers {
+		env = append(env, fmt.Sprintf("CGI_TRAILER_FD=%d", TrailerFD))
+	}
+
 	for _, e := range h.InheritEnv {
 		if v := os.Getenv(e); v != "" {
 			env = append(env, e+"="+v)
 		}
 	}
+	env = append(env, h.Env...)
 
-	env = removeLeadingDuplicates(env)
+	return env
+}
 
+// command returns the command that runs the executable with the environment env.
+// The commands stdin, stdout and stderr are left for the caller to set.
+func (h *Handler) command(env []string) *exec.Cmd {
 	var cwd, path string
 	if h.Dir != "" {
 		path = h.Path
@@ -171,40 +603,12 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		cwd = "."
 	}
 
-	internalError := func(err error) {
-		w.WriteHeader(http.StatusInternalServerError)
-		h.logErr("CGI error: %v", err)
-	}
-
-	cmd := &exec.Cmd{
-		Path:   path,
-		Args:   append([]string{h.Path}, h.Args...),
-		Dir:    cwd,
-		Env:    env,
-		Stderr: h.Stderr,
-	}
-
-	if r.ContentLength != 0 {
-		cmd.Stdin = r.Body
-	}
-	stdoutRead, err := cmd.StdoutPipe()
-	if err != nil {
-		internalError(err)
-		return
-	}
-	err = cmd.Start()
-	if err != nil {
-		internalError(err)
-		return
+	return &exec.Cmd{
+		Path: path,
+		Args: append([]string{h.Path}, h.Args...),
+		Dir:  cwd,
+		Env:  env,
 	}
-
-	defer cmd.Wait()
-	defer stdoutRead.Close()
-
-	h.OutputHandler(w, r, h, stdoutRead)
-
-	// Make sure the process is good and dead before exiting
-	cmd.Process.Kill()
 }
 
 func removeLeadingDuplicates(env []string) (ret []string) {
@@ -1,16 +1,36 @@
 package cgi
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 )
 
+func TestMain(m *testing.M) {
+	err := os.Chdir("../../test-assets")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error while moving into test-assets directory: %s\n", err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
 func TestHandler(t *testing.T) {
 	type test struct {
 		Name           string
@@ -20,6 +40,10 @@ func TestHandler(t *testing.T) {
 		ExpectedBody   string
 		Input          string
 		OutputHandler  OutputHandler
+		HeaderPolicy   HeaderPolicy
+		HeaderDeny     []string
+		MaxRequestBody int64
+		MaxHeaderSize  int
 	}
 
 	tt := []test{
@@ -50,6 +74,65 @@ func TestHandler(t *testing.T) {
 			ExpectedBody:  "./expected_body",
 			OutputHandler: EZOutputHandlerReplacer,
 		},
+		test{
+			Name:           "Locked headers",
+			Script:         "./headers.sh",
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{
+				"Content-Type": []string{"text/plain"},
+				"Test-Header":  []string{"PASS"},
+			},
+			ExpectedBody:  "./expected_body",
+			OutputHandler: EZOutputHandlerReplacer,
+			HeaderPolicy:  HeaderLocked,
+		},
+		test{
+			Name:           "Denied headers",
+			Script:         "./headers.sh",
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{
+				"Content-Type": []string{"text/html"},
+				"Test-Header":  []string{""},
+			},
+			ExpectedBody:  "./expected_body",
+			OutputHandler: EZOutputHandlerReplacer,
+			HeaderDeny:    []string{"test-header"},
+		},
+		test{
+			Name:           "Default handler replace headers",
+			Script:         "./headers.sh",
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{
+				"Content-Type": []string{"text/html"},
+				"Test-Header":  []string{"PASS"},
+			},
+			ExpectedBody:  "./expected_body",
+			OutputHandler: DefaultOutputHandler,
+		},
+		test{
+			Name:           "Default handler locked headers",
+			Script:         "./headers.sh",
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{
+				"Content-Type": []string{"text/plain"},
+				"Test-Header":  []string{"PASS"},
+			},
+			ExpectedBody:  "./expected_body",
+			OutputHandler: DefaultOutputHandler,
+			HeaderPolicy:  HeaderLocked,
+		},
+		test{
+			Name:           "Default handler denied headers",
+			Script:         "./headers.sh",
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{
+				"Content-Type": []string{"text/html"},
+				"Test-Header":  []string{""},
+			},
+			ExpectedBody:  "./expected_body",
+			OutputHandler: DefaultOutputHandler,
+			HeaderDeny:    []string{"test-header"},
+		},
 		test{
 			Name:           "Headers with no blank line",
 			Script:         "./headers_noblank.sh",
@@ -69,11 +152,79 @@ func TestHandler(t *testing.T) {
 			Input:          "./expected_body",
 			OutputHandler:  EZOutputHandlerReplacer,
 		},
-	}
-
-	err := os.Chdir("../../test-assets")
-	if err != nil {
-		t.Fatalf("error while moving into test-assets directory: %s", err)
+		test{
+			Name:           "Request body too large",
+			Script:         "./requestbody.sh",
+			ExpectedStatus: http.StatusRequestEntityTooLarge,
+			Input:          "./expected_body",
+			OutputHandler:  EZOutputHandlerReplacer,
+			MaxRequestBody: 8,
+		},
+		test{
+			Name:           "Header block too large",
+			Script:         "./headers.sh",
+			ExpectedStatus: http.StatusInternalServerError,
+			OutputHandler:  DefaultOutputHandler,
+			MaxHeaderSize:  10,
+		},
+		test{
+			Name:           "Long line treated as body",
+			Script:         "./noheaders.sh",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   "./expected_body",
+			OutputHandler:  EZOutputHandlerReplacer,
+			MaxHeaderSize:  2,
+		},
+		test{
+			Name:           "JSON envelope",
+			Script:         "./json.sh",
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{
+				"Content-Type": []string{"text/html"},
+				"Test-Header":  []string{"PASS"},
+			},
+			ExpectedBody:  "./expected_body",
+			OutputHandler: JSONOutputHandler,
+		},
+		test{
+			Name:           "Malformed JSON envelope",
+			Script:         "./json_malformed.sh",
+			ExpectedStatus: http.StatusBadGateway,
+			OutputHandler:  JSONOutputHandler,
+		},
+		test{
+			Name:           "NDJSON envelope",
+			Script:         "./ndjson.sh",
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{
+				"Content-Type": []string{"text/html"},
+				"Test-Header":  []string{"PASS"},
+			},
+			ExpectedBody:  "./expected_body",
+			OutputHandler: NDJSONOutputHandler,
+		},
+		test{
+			Name:           "Buffered exit status",
+			Script:         "./exitcode.sh",
+			ExpectedStatus: http.StatusNotFound,
+			OutputHandler: &BufferedOutputHandler{
+				OutputHandler: EZOutputHandler,
+				ExitStatus:    map[int]int{3: http.StatusNotFound},
+			},
+		},
+		test{
+			Name:           "Buffered spill to disk",
+			Script:         "./noheaders.sh",
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{
+				"Content-Type": []string{"text/plain"},
+			},
+			ExpectedBody: "./expected_body",
+			OutputHandler: &BufferedOutputHandler{
+				OutputHandler: EZOutputHandler,
+				MemoryLimit:   4,
+			},
+		},
 	}
 
 	for _, tc := range tt {
@@ -82,6 +233,11 @@ func TestHandler(t *testing.T) {
 				Path:          tc.Script,
 				Dir:           ".",
 				OutputHandler: tc.OutputHandler,
+				HeaderPolicy:  tc.HeaderPolicy,
+				HeaderDeny:    tc.HeaderDeny,
+
+				MaxRequestBodySize: tc.MaxRequestBody,
+				MaxHeaderSize:      tc.MaxHeaderSize,
 			}
 
 			var body io.Reader
@@ -133,3 +289,496 @@ func TestHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestSSEOutputHandler(t *testing.T) {
+	h := &Handler{
+		Path:          "./sse.sh",
+		Dir:           ".",
+		OutputHandler: NewSSEOutputHandler(0),
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+
+	h.ServeHTTP(w, r)
+
+	result := w.Result()
+	if ct := result.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("wrong content type - expected: text/event-stream\treceived: %s", ct)
+	}
+
+	expected := "event: greeting\nid: 1\ndata: hello\n\ndata: world\n\n"
+	received, err := ioutil.ReadAll(result.Body)
+	if err != nil {
+		t.Fatalf("error while reading body: %s", err)
+	}
+	if string(received) != expected {
+		t.Fatalf("wrong body - expected: %q\treceived: %q", expected, received)
+	}
+}
+
+func TestSSEKeepAlive(t *testing.T) {
+	h := &Handler{
+		Path:          "./sse_slow.sh",
+		Dir:           ".",
+		OutputHandler: NewSSEOutputHandler(50 * time.Millisecond),
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	received := w.Body.String()
+	if !strings.HasPrefix(received, "data: hello\n\n: keepalive\n\n") || !strings.HasSuffix(received, "data: world\n\n") {
+		t.Fatalf("expected keepalive comments between the events, received: %q", received)
+	}
+}
+
+func TestSSEDisconnect(t *testing.T) {
+	h, err := New("./sse_forever.sh",
+		WithDir("."),
+		WithOutputHandler(NewSSEOutputHandler(0)),
+		// The limiter is then read from by the output handler's goroutine, which may outlive the request.
+		WithMaxResponseSize(1<<20, false),
+	)
+	if err != nil {
+		t.Fatalf("error while creating handler: %s", err)
+	}
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, _ := http.NewRequest("GET", s.URL, nil)
+	resp, err := http.DefaultClient.Do(r.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("error while making request: %s", err)
+	}
+	event, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || event != "data: tick\n" {
+		t.Fatalf("wrong first event - expected: %q\treceived: %q (%v)", "data: tick\n", event, err)
+	}
+	cancel()
+	resp.Body.Close()
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer waitCancel()
+	if err := h.Wait(waitCtx); err != nil {
+		t.Fatal("client process still running after the HTTP client disconnected")
+	}
+}
+
+func TestWebSocketHandler(t *testing.T) {
+	wh := &WebSocketHandler{
+		Handler: &Handler{
+			Path: "./echo.sh",
+			Dir:  ".",
+		},
+	}
+	s := httptest.NewServer(wh)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("error while dialing server: %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n", s.Listener.Addr())
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("error while reading handshake response: %s", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("wrong status - expected: %d\treceived: %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("wrong accept key: %s", accept)
+	}
+
+	// Send a masked text frame
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x81, 0x80 | 4}
+	frame = append(frame, mask...)
+	for i, b := range []byte("PASS") {
+		frame = append(frame, b^mask[i%4])
+	}
+	conn.Write(frame)
+
+	readFrame := func() (byte, []byte) {
+		head := make([]byte, 2)
+		if _, err := io.ReadFull(br, head); err != nil {
+			t.Fatalf("error while reading frame: %s", err)
+		}
+		payload := make([]byte, head[1]&0x7F)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			t.Fatalf("error while reading frame: %s", err)
+		}
+		return head[0] & 0x0F, payload
+	}
+
+	opcode, payload := readFrame()
+	if opcode != wsOpText || string(payload) != "PASS" {
+		t.Fatalf("wrong message - expected: %q\treceived: %q (opcode %d)", "PASS", payload, opcode)
+	}
+
+	opcode, payload = readFrame()
+	if opcode != wsOpClose || len(payload) < 2 || binary.BigEndian.Uint16(payload) != wsCloseNormal {
+		t.Fatalf("expected normal close frame, received: %v (opcode %d)", payload, opcode)
+	}
+}
+
+// halfOpenConn is the server end of a connection whose client went away without closing it:
+// once broken, writes fail while reads keep blocking until the connection is closed.
+type halfOpenConn struct {
+	net.Conn
+	broken int32
+}
+
+func (c *halfOpenConn) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(&c.broken) != 0 {
+		return 0, errors.New("broken pipe")
+	}
+	return c.Conn.Write(b)
+}
+
+// hijackRecorder is a ResponseRecorder that hands over conn when hijacked.
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (hr *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hr.conn, bufio.NewReadWriter(bufio.NewReader(hr.conn), bufio.NewWriter(hr.conn)), nil
+}
+
+func TestWebSocketDisconnect(t *testing.T) {
+	h, err := New("./sse_forever.sh", WithDir("."))
+	if err != nil {
+		t.Fatalf("error while creating handler: %s", err)
+	}
+	wh := &WebSocketHandler{Handler: h}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := &halfOpenConn{Conn: server}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-WebSocket-Version", "13")
+	r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	done := make(chan struct{})
+	go func() {
+		wh.ServeHTTP(&hijackRecorder{ResponseRecorder: httptest.NewRecorder(), conn: conn}, r)
+		close(done)
+	}()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(client)
+	if _, err := http.ReadResponse(br, nil); err != nil {
+		t.Fatalf("error while reading handshake response: %s", err)
+	}
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(br, head); err != nil {
+		t.Fatalf("error while reading frame: %s", err)
+	}
+	if _, err := io.ReadFull(br, make([]byte, head[1]&0x7F)); err != nil {
+		t.Fatalf("error while reading frame: %s", err)
+	}
+
+	// The client goes away mid-stream without closing the connection.
+	atomic.StoreInt32(&conn.broken, 1)
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler still running after the client went away")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := h.Wait(ctx); err != nil {
+		t.Fatalf("executable still running after the client went away: %s", err)
+	}
+}
+
+type executionRecorder struct {
+	stdout   []byte
+	stderr   []byte
+	exitCode int
+	pid      int
+	env      []string
+}
+
+func (er *executionRecorder) HandleOutput(w http.ResponseWriter, r *http.Request, h *Handler, x *Execution) {
+	er.stdout, _ = ioutil.ReadAll(x.Stdout)
+	er.exitCode = x.ExitCode()
+	er.stderr = x.Stderr()
+	er.pid = x.Pid
+	er.env = x.Env
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestExecution(t *testing.T) {
+	er := &executionRecorder{}
+	h := &Handler{
+		Path:          "./exitcode.sh",
+		Dir:           ".",
+		Stderr:        ioutil.Discard,
+		OutputHandler: er,
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+
+	h.ServeHTTP(w, r)
+
+	if string(er.stdout) != "PASS\n" {
+		t.Fatalf("wrong stdout - expected: %q\treceived: %q", "PASS\n", er.stdout)
+	}
+	if er.exitCode != 3 {
+		t.Fatalf("wrong exit code - expected: %d\treceived: %d", 3, er.exitCode)
+	}
+	if string(er.stderr) != "STDERR\n" {
+		t.Fatalf("wrong stderr - expected: %q\treceived: %q", "STDERR\n", er.stderr)
+	}
+	if er.pid == 0 {
+		t.Fatal("missing pid")
+	}
+	if len(er.env) == 0 {
+		t.Fatal("missing env")
+	}
+}
+
+// TestHeaderMergeRace drives concurrent requests through the same Handler, run it with the -race flag.
+func TestHeaderMergeRace(t *testing.T) {
+	h := &Handler{
+		Path:          "./queryheader.sh",
+		Dir:           ".",
+		OutputHandler: EZOutputHandlerReplacer,
+		HeaderPolicy:  HeaderAppend,
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			query := ""
+			if i%2 == 0 {
+				query = fmt.Sprintf("?%d", i)
+			}
+			r := httptest.NewRequest("GET", "/"+query, nil)
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+
+			received := w.Result().Header["Test-Header"]
+			if query == "" && len(received) != 0 {
+				t.Errorf("header leaked into response: %v", received)
+			}
+			if query != "" && (len(received) != 1 || received[0] != query[1:]) {
+				t.Errorf("wrong header - expected: %s\treceived: %v", query[1:], received)
+			}
+			if ct := w.Result().Header["Content-Type"]; len(ct) != 2 {
+				t.Errorf("wrong content type - expected: [text/plain text/html]\treceived: %v", ct)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(h.Header) != 0 {
+		t.Fatalf("handler header was modified: %v", h.Header)
+	}
+}
+
+func TestMaxResponseSize(t *testing.T) {
+	h := &Handler{
+		Path:            "./noheaders.sh",
+		Dir:             ".",
+		OutputHandler:   EZOutputHandler,
+		MaxResponseSize: 5,
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+
+	h.ServeHTTP(w, r)
+
+	received, err := ioutil.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Fatalf("error while reading body: %s", err)
+	}
+	if string(received) != "PASS\n" {
+		t.Fatalf("wrong body - expected: %q\treceived: %q", "PASS\n", received)
+	}
+}
+
+func TestTrailers(t *testing.T) {
+	type test struct {
+		Name          string
+		Script        string
+		OutputHandler OutputHandler
+	}
+
+	tt := []test{
+		test{Name: "Trailers", Script: "./trailers.sh"},
+		// The response mustn't wait on the background process holding on to the trailer file descriptor.
+		test{Name: "Background process", Script: "./trailers_background.sh"},
+		test{Name: "Buffered", Script: "./trailers.sh", OutputHandler: &BufferedOutputHandler{}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			oh := tc.OutputHandler
+			if oh == nil {
+				oh = DefaultOutputHandler
+			}
+			h := &Handler{
+				Path:          tc.Script,
+				Dir:           ".",
+				OutputHandler: oh,
+				Trailers:      true,
+			}
+			s := httptest.NewServer(h)
+			defer s.Close()
+
+			start := time.Now()
+			resp, err := http.Get(s.URL)
+			if err != nil {
+				t.Fatalf("error while making request: %s", err)
+			}
+			defer resp.Body.Close()
+
+			received, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("error while reading body: %s", err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Fatalf("response took %s", elapsed)
+			}
+			if string(received) != "PASS\n" {
+				t.Fatalf("wrong body - expected: %q\treceived: %q", "PASS\n", received)
+			}
+			if rows := resp.Header.Get("X-Rows"); rows != "" {
+				t.Fatalf("trailer X-Rows sent in the header: %s", rows)
+			}
+			if rows := resp.Trailer.Get("X-Rows"); rows != "1" {
+				t.Fatalf("wrong trailer: X-Rows - expected: 1\treceived: %s", rows)
+			}
+		})
+	}
+}
+
+func TestEnv(t *testing.T) {
+	vars := []string{"GREETING=PASS"}
+	h, err := New("./env.sh",
+		WithDir("."),
+		WithOutputHandler(EZOutputHandler),
+		WithEnv(vars...),
+	)
+	if err != nil {
+		t.Fatalf("error while creating handler: %s", err)
+	}
+	// Modifying the caller's slice shouldn't have any effect on h.
+	vars[0] = "GREETING=FAIL"
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if w.Body.String() != "PASS\n" {
+		t.Fatalf("wrong body - expected: %q\treceived: %q", "PASS\n", w.Body.String())
+	}
+
+	if _, err := New("./env.sh", WithDir("."), WithEnv("GREETING")); err == nil {
+		t.Fatal("expected an error for a variable without a value")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	h, err := New("./sleep.sh",
+		WithDir("."),
+		WithOutputHandler(EZOutputHandler),
+		WithTimeout(100*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("error while creating handler: %s", err)
+	}
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("client process wasn't killed after timeout, request took %s", elapsed)
+	}
+	if w.Body.String() != "START\n" {
+		t.Fatalf("wrong body - expected: %q\treceived: %q", "START\n", w.Body.String())
+	}
+}
+
+func TestMethods(t *testing.T) {
+	h, err := New("./remoteaddr.sh",
+		WithDir("."),
+		WithOutputHandler(EZOutputHandler),
+		WithMethods("get", "POST"),
+	)
+	if err != nil {
+		t.Fatalf("error while creating handler: %s", err)
+	}
+
+	for method, expected := range map[string]int{"GET": http.StatusOK, "HEAD": http.StatusOK, "POST": http.StatusOK, "DELETE": http.StatusMethodNotAllowed} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, "/", nil))
+		if w.Code != expected {
+			t.Fatalf("wrong status for %s - expected: %d\treceived: %d", method, expected, w.Code)
+		}
+		if expected == http.StatusMethodNotAllowed && w.Header().Get("Allow") != "GET, HEAD, POST" {
+			t.Fatalf("wrong Allow header: %s", w.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestWaitAndSignal(t *testing.T) {
+	h, err := New("./sleep.sh",
+		WithDir("."),
+		WithOutputHandler(EZOutputHandler),
+	)
+	if err != nil {
+		t.Fatalf("error while creating handler: %s", err)
+	}
+
+	if err := h.Wait(context.Background()); err != nil {
+		t.Fatalf("error waiting on idle handler: %s", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		close(done)
+	}()
+
+	// Wait for the execution to be in flight.
+	for start := time.Now(); h.Signal(syscall.Signal(0)) == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 2*time.Second {
+			t.Fatal("execution never came in flight")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := h.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("wrong error waiting on busy handler - expected: %v\treceived: %v", context.DeadlineExceeded, err)
+	}
+
+	if n := h.Signal(syscall.SIGTERM); n != 1 {
+		t.Fatalf("wrong number of executions signaled - expected: 1\treceived: %d", n)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := h.Wait(ctx); err != nil {
+		t.Fatalf("error waiting on signaled handler: %s", err)
+	}
+	<-done
+}
@@ -0,0 +1,123 @@
+package cgi
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+)
+
+// DefaultMaxHeaderSize is the default maximum size in bytes of the header block written by the client process.
+const DefaultMaxHeaderSize = 64 << 10
+
+// errHeaderTooLarge is returned when the header block written by the client process is larger than Handler.MaxHeaderSize.
+var errHeaderTooLarge = errors.New("header block too large")
+
+// HeaderPolicy decides how header values written by the client process are merged with the default header values.
+type HeaderPolicy int
+
+const (
+	// HeaderOverride lets header values written by the client process replace the default values.
+	HeaderOverride HeaderPolicy = iota
+	// HeaderAppend adds header values written by the client process to the default values.
+	HeaderAppend
+	// HeaderLocked keeps the default header values, the client process may only set headers that don't have a default value.
+	HeaderLocked
+)
+
+// headerMerger merges the header values written by the client process into a per-request copy of the default header values.
+type headerMerger struct {
+	h      *Handler
+	header http.Header
+	// written keeps track of the headers that have already been written by the client process.
+	written map[string]bool
+}
+
+func (h *Handler) newHeaderMerger() *headerMerger {
+	return &headerMerger{
+		h:       h,
+		header:  h.Header.Clone(),
+		written: make(map[string]bool),
+	}
+}
+
+// add merges in the header value v for k written by the client process.
+// Reports whether the value was kept.
+func (m *headerMerger) add(k, v string) bool {
+	k = http.CanonicalHeaderKey(k)
+	if !m.h.scriptMaySet(k) {
+		return false
+	}
+
+	_, isDefault := m.h.Header[k]
+	switch {
+	case !isDefault:
+	case m.h.HeaderPolicy == HeaderLocked:
+		return false
+	case m.h.HeaderPolicy == HeaderOverride && !m.written[k]:
+		m.header.Del(k)
+	}
+	m.written[k] = true
+	m.header.Add(k, v)
+	return true
+}
+
+// scriptMaySet reports whether the client process is allowed to set the header k according to h's allow and deny lists.
+func (h *Handler) scriptMaySet(k string) bool {
+	for _, d := range h.HeaderDeny {
+		if http.CanonicalHeaderKey(d) == k {
+			return false
+		}
+	}
+	if len(h.HeaderAllow) == 0 {
+		return true
+	}
+	for _, a := range h.HeaderAllow {
+		if http.CanonicalHeaderKey(a) == k {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTo adds the merged header values to the header of w.
+func (m *headerMerger) writeTo(w http.ResponseWriter) {
+	for k, vv := range m.header {
+		for _, v := range vv {
+			w.Header().Add(k, v)
+		}
+	}
+}
+
+// headerReader reads the header block written by the client process line by line,
+// making sure it doesn't grow past the Handler's MaxHeaderSize.
+type headerReader struct {
+	br        *bufio.Reader
+	remaining int
+}
+
+func (h *Handler) newHeaderReader(br *bufio.Reader) *headerReader {
+	max := h.MaxHeaderSize
+	if max <= 0 {
+		max = DefaultMaxHeaderSize
+	}
+	return &headerReader{br: br, remaining: max}
+}
+
+// readLine returns the next line without its line ending.
+// If the line doesn't fit in what's left of the header block, errHeaderTooLarge is returned along with the part of the line read so far,
+// complete then reports whether that part is the whole line.
+func (hr *headerReader) readLine() (line []byte, complete bool, err error) {
+	for {
+		frag, isPrefix, err := hr.br.ReadLine()
+		line = append(line, frag...)
+		hr.remaining -= len(frag)
+		switch {
+		case hr.remaining < 0:
+			return line, !isPrefix && err == nil, errHeaderTooLarge
+		case err != nil:
+			return line, false, err
+		case !isPrefix:
+			return line, true, nil
+		}
+	}
+}
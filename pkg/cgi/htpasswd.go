@@ -0,0 +1,176 @@
+package cgi
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// HtpasswdAuth authenticates requests using HTTP Basic authentication against the users in an htpasswd file.
+// Supported password hashes are bcrypt ("$2y$", "$2a$" and "$2b$"), SHA-1 ("{SHA}") and Apache's MD5 ("$apr1$").
+// The file is reloaded whenever it changes.
+type HtpasswdAuth struct {
+	// Realm is sent to the HTTP client when challenging it to authenticate.
+	Realm string
+
+	file *watchedFile
+}
+
+// NewHtpasswdAuth returns an HtpasswdAuth for the htpasswd file at path.
+func NewHtpasswdAuth(path, realm string) (*HtpasswdAuth, error) {
+	file, err := newWatchedFile(path, parseHtpasswd)
+	if err != nil {
+		return nil, fmt.Errorf("cgi: error loading htpasswd file: %v", err)
+	}
+	return &HtpasswdAuth{Realm: realm, file: file}, nil
+}
+
+// Authenticate authenticates r, problems with the htpasswd file are logged with the log package.
+// Handler logs them with its own Logger instead.
+func (a *HtpasswdAuth) Authenticate(w http.ResponseWriter, r *http.Request) *Identity {
+	return a.authenticate(w, r, log.Printf)
+}
+
+func (a *HtpasswdAuth) authenticate(w http.ResponseWriter, r *http.Request, logf logFunc) *Identity {
+	user, password, ok := r.BasicAuth()
+	if ok {
+		users := a.file.get(logf).(map[string]string)
+		if hash, found := users[user]; found && checkHtpasswd(hash, password) {
+			return &Identity{User: user, AuthType: "Basic"}
+		}
+	}
+	unauthorized(w, fmt.Sprintf("Basic realm=%q", a.Realm))
+	return nil
+}
+
+// parseHtpasswd parses the contents of an htpasswd file into a map of users to password hashes.
+// Users with an unsupported hash are left out with a warning.
+func parseHtpasswd(data []byte) (interface{}, []string, error) {
+	users := make(map[string]string)
+	var warnings []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) < 2 {
+			return nil, nil, fmt.Errorf("line %d: expected 'USER:HASH'", n)
+		}
+		user, hash := parts[0], parts[1]
+		if !supportedHtpasswdHash(hash) {
+			warnings = append(warnings, fmt.Sprintf("line %d: unsupported hash for user %q, ignoring", n, user))
+			continue
+		}
+		users[user] = hash
+	}
+	return users, warnings, scanner.Err()
+}
+
+func supportedHtpasswdHash(hash string) bool {
+	for _, prefix := range []string{"$2y$", "$2a$", "$2b$", "{SHA}", "$apr1$"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHtpasswd reports whether password matches the htpasswd hash.
+func checkHtpasswd(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1
+	case strings.HasPrefix(hash, "$apr1$"):
+		parts := strings.SplitN(hash[len("$apr1$"):], "$", 2)
+		if len(parts) < 2 {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(apr1(password, parts[0]))) == 1
+	}
+	return false
+}
+
+// apr1 hashes password with Apache's variant of the MD5 based crypt algorithm.
+func apr1(password, salt string) string {
+	const magic = "$apr1$"
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write([]byte(salt))
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(pw)
+	ctx.Write([]byte(magic))
+	ctx.Write([]byte(salt))
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			ctx.Write(altSum)
+		} else {
+			ctx.Write(altSum[:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	final := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(pw)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(pw)
+		}
+		final = round.Sum(nil)
+	}
+
+	var out []byte
+	to64 := func(v uint, n int) {
+		for ; n > 0; n-- {
+			out = append(out, itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		to64(uint(final[g[0]])<<16|uint(final[g[1]])<<8|uint(final[g[2]]), 4)
+	}
+	to64(uint(final[11]), 2)
+
+	return magic + salt + "$" + string(out)
+}
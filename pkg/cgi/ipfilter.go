@@ -0,0 +1,124 @@
+package cgi
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+)
+
+// IPRule allows or denies HTTP clients from a network.
+type IPRule struct {
+	Allow bool
+	// Net is the network the rule applies to, nil means every client.
+	Net *net.IPNet
+}
+
+// ParseIPRule parses a rule of the form 'allow NETWORK' or 'deny NETWORK',
+// where NETWORK is an IP address, a CIDR such as 10.8.0.0/16, or 'all'.
+func ParseIPRule(s string) (IPRule, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return IPRule{}, fmt.Errorf("cgi: invalid IP rule %q: expected 'allow NETWORK' or 'deny NETWORK'", s)
+	}
+
+	var rule IPRule
+	switch strings.ToLower(fields[0]) {
+	case "allow":
+		rule.Allow = true
+	case "deny":
+	default:
+		return IPRule{}, fmt.Errorf("cgi: invalid IP rule %q: expected 'allow NETWORK' or 'deny NETWORK'", s)
+	}
+	if strings.ToLower(fields[1]) == "all" {
+		return rule, nil
+	}
+	n, err := ParseNetwork(fields[1])
+	if err != nil {
+		return IPRule{}, fmt.Errorf("cgi: invalid IP rule %q: %v", s, err)
+	}
+	rule.Net = n
+	return rule, nil
+}
+
+// ParseNetwork parses a CIDR such as 10.8.0.0/16, or a single IP address which is treated as a network of one.
+func ParseNetwork(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, n, err := net.ParseCIDR(s)
+		return n, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address: %s", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))}, nil
+}
+
+func (rule IPRule) matches(ip net.IP) bool {
+	return rule.Net == nil || (ip != nil && rule.Net.Contains(ip))
+}
+
+// IPFilter allows or denies HTTP clients based on their IP address.
+// Rules are evaluated in order and the first one matching the client decides whether it's allowed.
+// Clients matching no rule are denied if there are any allow rules, and allowed otherwise.
+type IPFilter struct {
+	// Rules are evaluated before the rules read from the file, if any.
+	Rules []IPRule
+
+	file *watchedFile
+}
+
+// LoadIPFilter returns an IPFilter with rules followed by the rules in the file at path, one per line.
+// Empty lines and lines starting with '#' are ignored. The file is reloaded whenever it changes.
+func LoadIPFilter(path string, rules ...IPRule) (*IPFilter, error) {
+	file, err := newWatchedFile(path, parseIPRules)
+	if err != nil {
+		return nil, fmt.Errorf("cgi: error loading IP rules: %v", err)
+	}
+	return &IPFilter{Rules: rules, file: file}, nil
+}
+
+// parseIPRules parses the contents of an IP rules file into a []IPRule.
+func parseIPRules(data []byte) (interface{}, []string, error) {
+	var rules []IPRule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := ParseIPRule(line)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil, scanner.Err()
+}
+
+// Allowed reports whether the HTTP client with the IP address ip is allowed.
+// Problems with the rules file are logged with the log package, Handler logs them with its own Logger instead.
+func (f *IPFilter) Allowed(ip net.IP) bool {
+	return f.allowed(ip, log.Printf)
+}
+
+func (f *IPFilter) allowed(ip net.IP, logf logFunc) bool {
+	rules := f.Rules
+	if f.file != nil {
+		rules = append(rules[:len(rules):len(rules)], f.file.get(logf).([]IPRule)...)
+	}
+
+	allowRules := false
+	for _, rule := range rules {
+		if rule.matches(ip) {
+			return rule.Allow
+		}
+		allowRules = allowRules || rule.Allow
+	}
+	return !allowRules
+}
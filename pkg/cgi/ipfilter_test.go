@@ -0,0 +1,158 @@
+package cgi
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIPFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ez-cgi-test")
+	if err != nil {
+		t.Fatalf("error while creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rulesFile := filepath.Join(dir, "rules")
+	err = ioutil.WriteFile(rulesFile, []byte("# VPN\nallow 10.8.0.0/16\nallow fd00::/8\n"), 0600)
+	if err != nil {
+		t.Fatalf("error while writing rules file: %s", err)
+	}
+
+	deny, err := ParseIPRule("deny 10.8.0.13")
+	if err != nil {
+		t.Fatalf("error while parsing rule: %s", err)
+	}
+	filter, err := LoadIPFilter(rulesFile, deny)
+	if err != nil {
+		t.Fatalf("error while loading rules: %s", err)
+	}
+	h, err := New("./remoteaddr.sh",
+		WithDir("."),
+		WithOutputHandler(EZOutputHandler),
+		WithIPFilter(filter),
+		WithTrustedProxies("127.0.0.1", "192.168.0.0/24"),
+	)
+	if err != nil {
+		t.Fatalf("error while creating handler: %s", err)
+	}
+
+	type test struct {
+		Name           string
+		RemoteAddr     string
+		Forwarded      string
+		ExpectedStatus int
+		ExpectedAddr   string
+	}
+
+	tt := []test{
+		test{Name: "Allowed", RemoteAddr: "10.8.1.2:1234", ExpectedStatus: http.StatusOK, ExpectedAddr: "10.8.1.2"},
+		test{Name: "Allowed IPv6", RemoteAddr: "[fd00::1]:1234", ExpectedStatus: http.StatusOK, ExpectedAddr: "fd00::1"},
+		test{Name: "Denied by earlier rule", RemoteAddr: "10.8.0.13:1234", ExpectedStatus: http.StatusForbidden},
+		test{Name: "No matching rule", RemoteAddr: "203.0.113.1:1234", ExpectedStatus: http.StatusForbidden},
+		test{Name: "Untrusted proxy", RemoteAddr: "203.0.113.1:1234", Forwarded: "10.8.1.2", ExpectedStatus: http.StatusForbidden},
+		test{Name: "Trusted proxy", RemoteAddr: "127.0.0.1:1234", Forwarded: "10.8.1.2", ExpectedStatus: http.StatusOK, ExpectedAddr: "10.8.1.2"},
+		test{Name: "Proxy chain", RemoteAddr: "127.0.0.1:1234", Forwarded: "10.8.1.2, 192.168.0.5", ExpectedStatus: http.StatusOK, ExpectedAddr: "10.8.1.2"},
+		test{Name: "Spoofed hop", RemoteAddr: "127.0.0.1:1234", Forwarded: "10.8.1.2, 203.0.113.1", ExpectedStatus: http.StatusForbidden},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.RemoteAddr
+			if tc.Forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tc.Forwarded)
+			}
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+
+			if w.Code != tc.ExpectedStatus {
+				t.Fatalf("wrong status - expected: %d\treceived: %d", tc.ExpectedStatus, w.Code)
+			}
+			if tc.ExpectedStatus != http.StatusOK {
+				return
+			}
+			if expected := tc.ExpectedAddr + "\n"; w.Body.String() != expected {
+				t.Fatalf("wrong body - expected: %q\treceived: %q", expected, w.Body.String())
+			}
+		})
+	}
+
+	// Replace the file and make sure the change is picked up.
+	err = ioutil.WriteFile(rulesFile, []byte("deny 10.8.1.0/24\n"), 0600)
+	if err != nil {
+		t.Fatalf("error while writing rules file: %s", err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(rulesFile, future, future)
+	filter.file.checked = time.Time{}
+
+	if filter.Allowed(net.ParseIP("10.8.1.2")) {
+		t.Fatal("10.8.1.2 allowed after reload")
+	}
+	if !filter.Allowed(net.ParseIP("203.0.113.1")) {
+		t.Fatal("203.0.113.1 denied after reload")
+	}
+}
+
+// TestIPFilterFirst makes sure denied clients are turned away before they learn anything about the route.
+func TestIPFilterFirst(t *testing.T) {
+	allow, err := ParseIPRule("allow 10.8.0.0/16")
+	if err != nil {
+		t.Fatalf("error while parsing rule: %s", err)
+	}
+	h, err := New("./remoteaddr.sh",
+		WithDir("."),
+		WithOutputHandler(EZOutputHandler),
+		WithIPFilter(&IPFilter{Rules: []IPRule{allow}}),
+		WithMethods("GET", "POST"),
+		WithCORS(&CORS{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET", "POST"}}),
+	)
+	if err != nil {
+		t.Fatalf("error while creating handler: %s", err)
+	}
+
+	type test struct {
+		Name           string
+		Method         string
+		RemoteAddr     string
+		ExpectedStatus int
+	}
+
+	tt := []test{
+		test{Name: "Allowed preflight", Method: "OPTIONS", RemoteAddr: "10.8.1.2:1234", ExpectedStatus: http.StatusNoContent},
+		test{Name: "Denied preflight", Method: "OPTIONS", RemoteAddr: "203.0.113.1:1234", ExpectedStatus: http.StatusForbidden},
+		test{Name: "Allowed method not allowed", Method: "DELETE", RemoteAddr: "10.8.1.2:1234", ExpectedStatus: http.StatusMethodNotAllowed},
+		test{Name: "Denied method not allowed", Method: "DELETE", RemoteAddr: "203.0.113.1:1234", ExpectedStatus: http.StatusForbidden},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.Method, "/", nil)
+			r.RemoteAddr = tc.RemoteAddr
+			r.Header.Set("Origin", "https://app.example.com")
+			r.Header.Set("Access-Control-Request-Method", "POST")
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+
+			if w.Code != tc.ExpectedStatus {
+				t.Fatalf("wrong status - expected: %d\treceived: %d", tc.ExpectedStatus, w.Code)
+			}
+			if tc.ExpectedStatus != http.StatusForbidden {
+				return
+			}
+			for _, k := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Methods", "Allow"} {
+				if v := w.Header().Get(k); v != "" {
+					t.Fatalf("denied client received %s: %s", k, v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package cgi
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// jsonEnvelope is the structured response written by the client process for JSONOutputHandler and NDJSONOutputHandler.
+type jsonEnvelope struct {
+	Status     int                        `json:"status"`
+	Headers    map[string]jsonHeaderValue `json:"headers"`
+	Body       *string                    `json:"body"`
+	BodyBase64 *string                    `json:"body_base64"`
+}
+
+// jsonHeaderValue is a header value that may be written either as a single string or as an array of strings.
+type jsonHeaderValue []string
+
+func (v *jsonHeaderValue) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*v = jsonHeaderValue{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(data, &ss); err != nil {
+		return errors.New("header values must be a string or an array of strings")
+	}
+	*v = ss
+	return nil
+}
+
+// body returns the decoded body of the envelope, which may be empty.
+func (e *jsonEnvelope) body() ([]byte, error) {
+	switch {
+	case e.Body != nil && e.BodyBase64 != nil:
+		return nil, errors.New("only one of body and body_base64 may be set")
+	case e.BodyBase64 != nil:
+		b, err := base64.StdEncoding.DecodeString(*e.BodyBase64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body_base64: %v", err)
+		}
+		return b, nil
+	case e.Body != nil:
+		return []byte(*e.Body), nil
+	}
+	return nil, nil
+}
+
+// writeHeader writes out the envelopes headers merged with the default header values followed by its status code.
+func (e *jsonEnvelope) writeHeader(w http.ResponseWriter, h *Handler) {
+	header := h.newHeaderMerger()
+	for k, vv := range e.Headers {
+		for _, v := range vv {
+			header.add(k, v)
+		}
+	}
+	header.writeTo(w)
+	status := e.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+}
+
+func (e *jsonEnvelope) validate() error {
+	if e.Status != 0 && (e.Status < 100 || e.Status > 999) {
+		return fmt.Errorf("invalid status: %d", e.Status)
+	}
+	return nil
+}
+
+// JSONOutputHandler expects the client process to write out a single JSON object describing the response:
+//
+//	{"status": 201, "headers": {"Content-Type": "application/json"}, "body": "..."}
+//
+// The status defaults to 200 and header values may be either a string or an array of strings.
+// Binary bodies may be sent base64 encoded in "body_base64" instead of "body".
+// Headers in the envelope are merged with the default header values according to the Handler's HeaderPolicy.
+// If the client process' output isn't a valid envelope the HTTP client is sent a 502 status code.
+var JSONOutputHandler OutputHandler = OutputHandlerFunc(func(w http.ResponseWriter, r *http.Request, h *Handler, stdoutRead io.Reader) {
+	badGateway := func(err error) {
+		w.WriteHeader(http.StatusBadGateway)
+		h.logErr("cgi: malformed JSON envelope: %v", err)
+	}
+
+	var envelope jsonEnvelope
+	dec := json.NewDecoder(stdoutRead)
+	if err := dec.Decode(&envelope); err != nil {
+		badGateway(err)
+		return
+	}
+	// Make sure there's nothing but whitespace following the envelope.
+	if _, err := dec.Token(); err != io.EOF {
+		badGateway(errors.New("unexpected data after envelope"))
+		return
+	}
+	if err := envelope.validate(); err != nil {
+		badGateway(err)
+		return
+	}
+	body, err := envelope.body()
+	if err != nil {
+		badGateway(err)
+		return
+	}
+
+	envelope.writeHeader(w, h)
+	if _, err := w.Write(body); err != nil {
+		h.logErr("cgi: copy error: %v", err)
+	}
+})
+
+// NDJSONOutputHandler is the streaming variant of JSONOutputHandler.
+// The client process writes out newline delimited JSON objects.
+// The first one is an envelope just like for JSONOutputHandler, each one after that is a body chunk:
+//
+//	{"body": "..."} or {"body_base64": "..."}
+//
+// Each chunk is sent to the HTTP client as soon as it is read.
+// If the envelope is malformed the HTTP client is sent a 502 status code,
+// if a chunk is malformed the response is cut short.
+var NDJSONOutputHandler OutputHandler = OutputHandlerFunc(func(w http.ResponseWriter, r *http.Request, h *Handler, stdoutRead io.Reader) {
+	linebody := bufio.NewReaderSize(stdoutRead, 1024)
+	flusher, _ := w.(http.Flusher)
+
+	// nextLine returns the next non-blank line, or io.EOF when there are no more.
+	nextLine := func() (string, error) {
+		for {
+			line, err := linebody.ReadString('\n')
+			if strings.TrimSpace(line) != "" {
+				return line, nil
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	line, err := nextLine()
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		h.logErr("cgi: malformed JSON envelope: %v", err)
+		return
+	}
+	var envelope jsonEnvelope
+	if err := json.Unmarshal([]byte(line), &envelope); err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		h.logErr("cgi: malformed JSON envelope: %v", err)
+		return
+	}
+	if err := envelope.validate(); err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		h.logErr("cgi: malformed JSON envelope: %v", err)
+		return
+	}
+	body, err := envelope.body()
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		h.logErr("cgi: malformed JSON envelope: %v", err)
+		return
+	}
+
+	envelope.writeHeader(w, h)
+
+	for {
+		if _, err := w.Write(body); err != nil {
+			h.logErr("cgi: copy error: %v", err)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		line, err := nextLine()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			h.logErr("cgi: error reading body chunk: %v", err)
+			return
+		}
+		var chunk jsonEnvelope
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			h.logErr("cgi: malformed JSON body chunk: %v", err)
+			return
+		}
+		if body, err = chunk.body(); err != nil {
+			h.logErr("cgi: malformed JSON body chunk: %v", err)
+			return
+		}
+	}
+})
@@ -0,0 +1,362 @@
+package cgi
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	// Register the hash functions used by the supported signing algorithms.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// JWTKey is a key used to verify the signature of JSON Web Tokens.
+type JWTKey struct {
+	// ID is matched against the "kid" header of tokens, an empty ID matches any token.
+	ID string
+	// Key is either a []byte for HMAC signatures, an *rsa.PublicKey for RSA signatures or an *ecdsa.PublicKey for ECDSA signatures.
+	Key interface{}
+}
+
+// JWTAuth authenticates requests bearing a JSON Web Token in their "Authorization: Bearer" header.
+// Tokens must be signed with one of HS256, HS384, HS512, RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384 or ES512
+// by one of Keys. The "exp" and "nbf" claims are checked if present, "aud" and "iss" are checked if Audience and Issuer are set.
+//
+// Requests with a missing or invalid token are responded to with a 401 before the executable is run.
+type JWTAuth struct {
+	// Keys are the keys token signatures are verified with.
+	Keys []JWTKey
+	// Audience, if set, must be in the tokens "aud" claim.
+	Audience string
+	// Issuer, if set, must be the tokens "iss" claim.
+	Issuer string
+	// Leeway is how much clock skew is tolerated when checking the "exp" and "nbf" claims.
+	Leeway time.Duration
+	// Claims lists the claims passed on to the executable, each in a JWT_CLAIM_<NAME> environment variable.
+	// Claims that aren't strings are passed on JSON encoded.
+	Claims []string
+	// UserClaim is the claim passed on to the executable in REMOTE_USER.
+	// Defaults to "sub".
+	UserClaim string
+	// Realm is sent to the HTTP client when challenging it to authenticate.
+	Realm string
+}
+
+func (a *JWTAuth) Authenticate(w http.ResponseWriter, r *http.Request) *Identity {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len("Bearer ") || !strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		unauthorized(w, fmt.Sprintf("Bearer realm=%q", a.Realm))
+		return nil
+	}
+
+	claims, err := a.verify(strings.TrimSpace(auth[len("Bearer "):]), time.Now())
+	if err != nil {
+		unauthorized(w, fmt.Sprintf("Bearer realm=%q, error=\"invalid_token\", error_description=%q", a.Realm, err.Error()))
+		return nil
+	}
+
+	userClaim := a.UserClaim
+	if userClaim == "" {
+		userClaim = "sub"
+	}
+	id := &Identity{AuthType: "Bearer"}
+	id.User, _ = claims[userClaim].(string)
+	for _, c := range a.Claims {
+		v, ok := claims[c]
+		if !ok {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			b, _ := json.Marshal(v)
+			s = string(b)
+		}
+		id.Env = append(id.Env, "JWT_CLAIM_"+envVarName(c)+"="+s)
+	}
+	return id
+}
+
+// verify checks the signature and claims of token, returning its claims if it's valid.
+func (a *JWTAuth) verify(token string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, errors.New("malformed token header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, k := range a.Keys {
+		if k.ID != "" && header.Kid != "" && k.ID != header.Kid {
+			continue
+		}
+		if verifyJWTSignature(header.Alg, k.Key, signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("invalid signature")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, errors.New("malformed token claims")
+	}
+
+	if exp, ok := claims["exp"]; ok {
+		t, ok := jwtTime(exp)
+		if !ok || !now.Before(t.Add(a.Leeway)) {
+			return nil, errors.New("token is expired")
+		}
+	}
+	if nbf, ok := claims["nbf"]; ok {
+		t, ok := jwtTime(nbf)
+		if !ok || now.Add(a.Leeway).Before(t) {
+			return nil, errors.New("token is not valid yet")
+		}
+	}
+	if a.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != a.Issuer {
+			return nil, errors.New("invalid issuer")
+		}
+	}
+	if a.Audience != "" && !jwtHasAudience(claims["aud"], a.Audience) {
+		return nil, errors.New("invalid audience")
+	}
+
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func jwtTime(v interface{}) (time.Time, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+func jwtHasAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func verifyJWTSignature(alg string, key interface{}, signed, sig []byte) bool {
+	if len(alg) != 5 {
+		return false
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return false
+	}
+
+	switch alg[:2] {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), sig)
+	}
+
+	hasher := hash.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	switch alg[:2] {
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pub, hash, digest, sig) == nil
+	case "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPSS(pub, hash, digest, sig, nil) == nil
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return false
+		}
+		// Each ECDSA algorithm is tied to a curve: ES256 to P-256, ES384 to P-384 and ES512 to P-521.
+		bits := pub.Curve.Params().BitSize
+		if alg[2:] != map[int]string{256: "256", 384: "384", 521: "512"}[bits] {
+			return false
+		}
+		size := (bits + 7) / 8
+		if len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(pub, digest, r, s)
+	}
+	return false
+}
+
+// ParseJWTPublicKey parses a PEM encoded RSA or ECDSA public key, or certificate, into a JWTKey.
+func ParseJWTPublicKey(data []byte) (JWTKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return JWTKey{}, errors.New("cgi: no PEM data found")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return JWTKey{}, fmt.Errorf("cgi: invalid public key: %v", err)
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return JWTKey{Key: key}, nil
+	}
+	return JWTKey{}, fmt.Errorf("cgi: unsupported public key type: %T", key)
+}
+
+// ParseJWKS parses a JSON Web Key Set.
+// RSA, EC (P-256, P-384 and P-521) and symmetric ("oct") keys are supported, other keys are skipped.
+func ParseJWKS(data []byte) ([]JWTKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("cgi: invalid JWKS: %v", err)
+	}
+
+	var keys []JWTKey
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		b64 := func(s string) *big.Int {
+			b, err := base64.RawURLEncoding.DecodeString(s)
+			if err != nil || len(b) == 0 {
+				return nil
+			}
+			return new(big.Int).SetBytes(b)
+		}
+
+		var key interface{}
+		switch k.Kty {
+		case "RSA":
+			n, e := b64(k.N), b64(k.E)
+			if n == nil || e == nil || !e.IsInt64() {
+				return nil, fmt.Errorf("cgi: invalid JWKS: key %d: invalid RSA key", i)
+			}
+			key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			curves := map[string]elliptic.Curve{
+				"P-256": elliptic.P256(),
+				"P-384": elliptic.P384(),
+				"P-521": elliptic.P521(),
+			}
+			curve, ok := curves[k.Crv]
+			x, y := b64(k.X), b64(k.Y)
+			if !ok || x == nil || y == nil || !curve.IsOnCurve(x, y) {
+				return nil, fmt.Errorf("cgi: invalid JWKS: key %d: invalid EC key", i)
+			}
+			key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil || len(secret) == 0 {
+				return nil, fmt.Errorf("cgi: invalid JWKS: key %d: invalid symmetric key", i)
+			}
+			key = secret
+		default:
+			continue
+		}
+		keys = append(keys, JWTKey{ID: k.Kid, Key: key})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("cgi: invalid JWKS: no usable keys")
+	}
+	return keys, nil
+}
+
+// envVarName turns s into a valid environment variable name: upper case letters, digits and underscores.
+func envVarName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - ('a' - 'A')
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,47 @@
+package cgi
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+// ErrResponseTooLarge is returned when reading more than Handler.MaxResponseSize bytes of the client process' stdout.
+var ErrResponseTooLarge = errors.New("cgi: response too large")
+
+// stdoutLimiter limits how much of the client process' stdout can be read, killing it once the limit is reached.
+type stdoutLimiter struct {
+	x         *Execution
+	r         io.Reader
+	remaining int64
+	// exceeded is set atomically since output handlers may still be reading in their own goroutine when Handler checks it.
+	exceeded int32
+}
+
+// wasExceeded reports whether the limit has been reached.
+func (l *stdoutLimiter) wasExceeded() bool {
+	return atomic.LoadInt32(&l.exceeded) == 1
+}
+
+func (l *stdoutLimiter) Read(p []byte) (int, error) {
+	if l.wasExceeded() {
+		return 0, ErrResponseTooLarge
+	}
+	if l.remaining <= 0 {
+		// Only call it exceeded if there's actually more output.
+		var probe [1]byte
+		n, err := l.r.Read(probe[:])
+		if n == 0 {
+			return 0, err
+		}
+		atomic.StoreInt32(&l.exceeded, 1)
+		l.x.Kill()
+		return 0, ErrResponseTooLarge
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
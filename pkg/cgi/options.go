@@ -0,0 +1,450 @@
+package cgi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Option configures a Handler created with New.
+type Option func(h *Handler) error
+
+// New returns a Handler that runs the executable at path, configured by opts.
+// Unlike a Handler created as a struct literal, the configuration is validated up front:
+// the executable must exist and be runnable and the working directory must exist.
+// Defaults are filled in and the parts of the environment that don't depend on the request are computed once.
+//
+// The returned Handler is safe for concurrent use and is immutable;
+// modifying its fields after New returns has no effect on how requests are handled.
+func New(path string, opts ...Option) (*Handler, error) {
+	h := &Handler{Path: path}
+	for _, opt := range opts {
+		if err := opt(h); err != nil {
+			return nil, err
+		}
+	}
+
+	if h.Path == "" {
+		return nil, errors.New("cgi: missing executable path")
+	}
+	// Windows can't hand extra file descriptors to the client process.
+	if h.Trailers && runtime.GOOS == "windows" {
+		return nil, errors.New("cgi: trailers aren't supported on windows")
+	}
+
+	// Resolve the working directory and executable the same way command does, but as absolute paths.
+	dir := h.Dir
+	if dir == "" {
+		dir = filepath.Dir(h.Path)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cgi: invalid working directory: %v", err)
+	}
+	if fi, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("cgi: invalid working directory: %v", err)
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("cgi: invalid working directory: %s is not a directory", dir)
+	}
+	exe := h.Path
+	switch {
+	case h.Dir == "":
+		exe, err = filepath.Abs(exe)
+		if err != nil {
+			return nil, fmt.Errorf("cgi: invalid executable: %v", err)
+		}
+	case !filepath.IsAbs(exe):
+		exe = filepath.Join(dir, exe)
+	}
+	if err := checkExecutable(exe); err != nil {
+		return nil, err
+	}
+	h.Dir = dir
+	h.Path = exe
+
+	// The sealed copy is what actually serves requests, it's kept private so that it can't be modified.
+	sealed := h.withDefaults()
+	sealed.unshare()
+	sealed.staticEnv = sealed.buildStaticEnv()
+	sealed.running = newRunning()
+	if strings.Contains(sealed.Root, "{") {
+		if sealed.pattern, err = ParsePattern(sealed.Root); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range sealed.ArgTemplates {
+		if err := t.checkParams(sealed.pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	// The returned copy gets maps and slices of its own too, modifying them mustn't reach the sealed copy.
+	c := *sealed
+	c.unshare()
+	c.sealed = sealed
+	return &c, nil
+}
+
+// unshare replaces the maps and slices of h with copies, so that h no longer shares them with any other Handler.
+func (h *Handler) unshare() {
+	h.Header = h.Header.Clone()
+	h.ArgTemplates = append([]*ArgTemplate(nil), h.ArgTemplates...)
+	h.InheritEnv = append([]string(nil), h.InheritEnv...)
+	h.Args = append([]string(nil), h.Args...)
+	h.Env = append([]string(nil), h.Env...)
+	h.HeaderAllow = append([]string(nil), h.HeaderAllow...)
+	h.HeaderDeny = append([]string(nil), h.HeaderDeny...)
+	h.Methods = append([]string(nil), h.Methods...)
+	proxies := h.TrustedProxies
+	h.TrustedProxies = nil
+	for _, n := range proxies {
+		h.TrustedProxies = append(h.TrustedProxies, &net.IPNet{
+			IP:   append(net.IP(nil), n.IP...),
+			Mask: append(net.IPMask(nil), n.Mask...),
+		})
+	}
+}
+
+func checkExecutable(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("cgi: invalid executable: %v", err)
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("cgi: invalid executable: %s is a directory", path)
+	}
+	if runtime.GOOS != "windows" && fi.Mode()&0111 == 0 {
+		return fmt.Errorf("cgi: invalid executable: %s is not executable", path)
+	}
+	return nil
+}
+
+// WithRoot sets the path prefix the Handler is mounted at, it must start with a "/" and may have named parameters.
+// See Handler.Root.
+func WithRoot(root string) Option {
+	return func(h *Handler) error {
+		if !strings.HasPrefix(root, "/") {
+			return fmt.Errorf("cgi: root must start with a '/': %q", root)
+		}
+		if _, err := ParsePattern(root); err != nil {
+			return err
+		}
+		h.Root = root
+		return nil
+	}
+}
+
+// WithArgTemplates adds command line arguments built from each request, see ArgTemplate.
+// See Handler.ArgTemplates.
+func WithArgTemplates(templates ...string) Option {
+	return func(h *Handler) error {
+		for _, s := range templates {
+			t, err := ParseArgTemplate(s)
+			if err != nil {
+				return err
+			}
+			h.ArgTemplates = append(h.ArgTemplates, t)
+		}
+		return nil
+	}
+}
+
+// WithParamArgs passes on the values of the Handler's Root parameters as command line arguments too.
+// See Handler.ParamArgs.
+func WithParamArgs() Option {
+	return func(h *Handler) error {
+		h.ParamArgs = true
+		return nil
+	}
+}
+
+// WithName sets the value of the SERVER_SOFTWARE environment variable.
+func WithName(name string) Option {
+	return func(h *Handler) error {
+		h.Name = name
+		return nil
+	}
+}
+
+// WithDir sets the working directory of the executable.
+func WithDir(dir string) Option {
+	return func(h *Handler) error {
+		h.Dir = dir
+		return nil
+	}
+}
+
+// WithArgs sets the arguments passed to the executable.
+func WithArgs(args ...string) Option {
+	return func(h *Handler) error {
+		h.Args = args
+		return nil
+	}
+}
+
+// WithInheritEnv sets the environment variables passed on from the server's environment to the executable.
+func WithInheritEnv(vars ...string) Option {
+	return func(h *Handler) error {
+		h.InheritEnv = vars
+		return nil
+	}
+}
+
+// WithEnv sets extra environment variables to pass on to the executable, each in the form 'KEY=VALUE'.
+func WithEnv(vars ...string) Option {
+	return func(h *Handler) error {
+		for _, v := range vars {
+			if i := strings.Index(v, "="); i <= 0 {
+				return fmt.Errorf("cgi: invalid environment variable %q: must be in the form 'KEY=VALUE'", v)
+			}
+		}
+		h.Env = append([]string(nil), vars...)
+		return nil
+	}
+}
+
+// WithTimeout sets how long the executable may run for handling a request before it's killed.
+// See Handler.Timeout.
+func WithTimeout(d time.Duration) Option {
+	return func(h *Handler) error {
+		if d < 0 {
+			return fmt.Errorf("cgi: invalid timeout: %s", d)
+		}
+		h.Timeout = d
+		return nil
+	}
+}
+
+// WithLogger sets the logger errors are written to.
+func WithLogger(logger *log.Logger) Option {
+	return func(h *Handler) error {
+		h.Logger = logger
+		return nil
+	}
+}
+
+// WithStderr sets where the executable's stderr is written to.
+func WithStderr(w io.Writer) Option {
+	return func(h *Handler) error {
+		h.Stderr = w
+		return nil
+	}
+}
+
+// WithHeader sets the default header values, header is copied.
+func WithHeader(header http.Header) Option {
+	return func(h *Handler) error {
+		h.Header = header.Clone()
+		return nil
+	}
+}
+
+// WithHeaderPolicy sets how headers written by the executable are merged with the default header values.
+func WithHeaderPolicy(policy HeaderPolicy) Option {
+	return func(h *Handler) error {
+		if policy < HeaderOverride || policy > HeaderLocked {
+			return fmt.Errorf("cgi: invalid header policy: %d", policy)
+		}
+		h.HeaderPolicy = policy
+		return nil
+	}
+}
+
+// WithHeaderAllow sets the only headers the executable is allowed to set.
+func WithHeaderAllow(headers ...string) Option {
+	return func(h *Handler) error {
+		h.HeaderAllow = headers
+		return nil
+	}
+}
+
+// WithHeaderDeny sets the headers the executable is never allowed to set.
+func WithHeaderDeny(headers ...string) Option {
+	return func(h *Handler) error {
+		h.HeaderDeny = headers
+		return nil
+	}
+}
+
+// WithOutputHandler sets the OutputHandler used to respond to the HTTP client.
+func WithOutputHandler(oh OutputHandler) Option {
+	return func(h *Handler) error {
+		if oh == nil {
+			return errors.New("cgi: nil output handler")
+		}
+		h.OutputHandler = oh
+		return nil
+	}
+}
+
+// WithMaxRequestBodySize sets the maximum size in bytes of a request body.
+// See Handler.MaxRequestBodySize.
+func WithMaxRequestBodySize(n int64) Option {
+	return func(h *Handler) error {
+		if n < 0 {
+			return fmt.Errorf("cgi: invalid max request body size: %d", n)
+		}
+		h.MaxRequestBodySize = n
+		return nil
+	}
+}
+
+// WithMaxHeaderSize sets the maximum size in bytes of the header block written by the executable.
+// See Handler.MaxHeaderSize.
+func WithMaxHeaderSize(n int) Option {
+	return func(h *Handler) error {
+		if n < 0 {
+			return fmt.Errorf("cgi: invalid max header size: %d", n)
+		}
+		h.MaxHeaderSize = n
+		return nil
+	}
+}
+
+// WithMaxResponseSize sets the maximum number of bytes read from the executable's stdout.
+// If abort is true the connection to the HTTP client is aborted when the limit is reached instead of truncating the response.
+// See Handler.MaxResponseSize.
+func WithMaxResponseSize(n int64, abort bool) Option {
+	return func(h *Handler) error {
+		if n < 0 {
+			return fmt.Errorf("cgi: invalid max response size: %d", n)
+		}
+		h.MaxResponseSize = n
+		h.AbortOversizedResponse = abort
+		return nil
+	}
+}
+
+// WithFormEnv passes on the query and form parameters of requests to the client CGI process in environment variables.
+// See Handler.FormEnv.
+func WithFormEnv(f *FormEnv) Option {
+	return func(h *Handler) error {
+		if f == nil {
+			return errors.New("cgi: nil form env")
+		}
+		if f.MultiValue < MultiValueJoin || f.MultiValue > MultiValueIndexed {
+			return fmt.Errorf("cgi: invalid multi-value policy: %d", f.MultiValue)
+		}
+		h.FormEnv = f
+		return nil
+	}
+}
+
+// WithUploads has multipart/form-data request bodies parsed and their files spooled for the client CGI process.
+// See Handler.Uploads.
+func WithUploads(u *Uploads) Option {
+	return func(h *Handler) error {
+		if u == nil {
+			return errors.New("cgi: nil uploads")
+		}
+		if u.Dir != "" {
+			if fi, err := os.Stat(u.Dir); err != nil {
+				return fmt.Errorf("cgi: invalid upload directory: %v", err)
+			} else if !fi.IsDir() {
+				return fmt.Errorf("cgi: invalid upload directory: %s is not a directory", u.Dir)
+			}
+		}
+		h.Uploads = u
+		return nil
+	}
+}
+
+// WithTrailers lets the executable write out HTTP trailers on file descriptor TrailerFD.
+// See Handler.Trailers.
+func WithTrailers() Option {
+	return func(h *Handler) error {
+		h.Trailers = true
+		return nil
+	}
+}
+
+// WithAuth sets the Authenticator used to authenticate requests before the executable is run.
+// See Handler.Auth.
+func WithAuth(auth Authenticator) Option {
+	return func(h *Handler) error {
+		h.Auth = auth
+		return nil
+	}
+}
+
+// WithMethods sets the only request methods the executable is run for.
+// See Handler.Methods.
+func WithMethods(methods ...string) Option {
+	return func(h *Handler) error {
+		h.Methods = nil
+		for _, m := range methods {
+			m = strings.ToUpper(strings.TrimSpace(m))
+			if m == "" {
+				return errors.New("cgi: invalid method: empty method")
+			}
+			h.Methods = append(h.Methods, m)
+		}
+		return nil
+	}
+}
+
+// WithCORS enables Cross-Origin Resource Sharing.
+// See Handler.CORS.
+func WithCORS(c *CORS) Option {
+	return func(h *Handler) error {
+		if c == nil {
+			return errors.New("cgi: nil CORS configuration")
+		}
+		if len(c.AllowedOrigins) == 0 {
+			return errors.New("cgi: invalid CORS configuration: no allowed origins")
+		}
+		h.CORS = c
+		return nil
+	}
+}
+
+// WithRateLimit sets the RateLimiter limiting how often each HTTP client can have the executable run.
+// See Handler.RateLimit.
+func WithRateLimit(l *RateLimiter) Option {
+	return func(h *Handler) error {
+		if l == nil {
+			return errors.New("cgi: nil rate limiter")
+		}
+		if l.Rate <= 0 || l.Burst < 1 {
+			return errors.New("cgi: invalid rate limit: rate must be positive and burst at least 1")
+		}
+		if l.Key == RateLimitByHeader && l.Header == "" {
+			return errors.New("cgi: invalid rate limit: missing header")
+		}
+		h.RateLimit = l
+		return nil
+	}
+}
+
+// WithIPFilter sets the IPFilter deciding which HTTP clients are allowed.
+// See Handler.IPFilter.
+func WithIPFilter(f *IPFilter) Option {
+	return func(h *Handler) error {
+		h.IPFilter = f
+		return nil
+	}
+}
+
+// WithTrustedProxies sets the networks of the reverse proxies whose X-Forwarded-For header is trusted.
+// Each network is either a CIDR or a single IP address.
+// See Handler.TrustedProxies.
+func WithTrustedProxies(networks ...string) Option {
+	return func(h *Handler) error {
+		for _, s := range networks {
+			n, err := ParseNetwork(s)
+			if err != nil {
+				return fmt.Errorf("cgi: invalid trusted proxy: %v", err)
+			}
+			h.TrustedProxies = append(h.TrustedProxies, n)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,127 @@
+package cgi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	type test struct {
+		Name    string
+		Path    string
+		Options []Option
+		Valid   bool
+	}
+
+	tt := []test{
+		test{
+			Name:  "Valid executable",
+			Path:  "./noheaders.sh",
+			Valid: true,
+		},
+		test{
+			Name:    "Valid executable in dir",
+			Path:    "noheaders.sh",
+			Options: []Option{WithDir(".")},
+			Valid:   true,
+		},
+		test{
+			Name: "Missing executable",
+			Path: "./missing.sh",
+		},
+		test{
+			Name: "Not executable",
+			Path: "./expected_body",
+		},
+		test{
+			Name: "Directory",
+			Path: ".",
+		},
+		test{
+			Name:    "Missing dir",
+			Path:    "noheaders.sh",
+			Options: []Option{WithDir("./missing")},
+		},
+		test{
+			Name:    "Invalid root",
+			Path:    "./noheaders.sh",
+			Options: []Option{WithRoot("api")},
+		},
+		test{
+			Name:    "Nil output handler",
+			Path:    "./noheaders.sh",
+			Options: []Option{WithOutputHandler(nil)},
+		},
+		test{
+			Name:    "Nil form env",
+			Path:    "./noheaders.sh",
+			Options: []Option{WithFormEnv(nil)},
+		},
+		test{
+			Name:    "Nil uploads",
+			Path:    "./noheaders.sh",
+			Options: []Option{WithUploads(nil)},
+		},
+		test{
+			Name:    "Nil CORS",
+			Path:    "./noheaders.sh",
+			Options: []Option{WithCORS(nil)},
+		},
+		test{
+			Name:    "Nil rate limiter",
+			Path:    "./noheaders.sh",
+			Options: []Option{WithRateLimit(nil)},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := New(tc.Path, tc.Options...)
+			if tc.Valid && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !tc.Valid && err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestNewImmutable(t *testing.T) {
+	header := http.Header{"Test-Header": []string{"PASS"}}
+	args := []string{"PASS"}
+	h, err := New("./args.sh", WithArgs(args...), WithHeader(header), WithOutputHandler(EZOutputHandler))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// None of these should have any effect on the requests served by h.
+	h.Path = "./missing.sh"
+	h.OutputHandler = DefaultOutputHandler
+	header.Set("Test-Header", "FAIL")
+	args[0] = "FAIL"
+	h.Header.Set("Test-Header", "FAIL")
+	h.Header.Set("Other-Header", "FAIL")
+	h.Args[0] = "FAIL"
+
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+
+	h.ServeHTTP(w, r)
+
+	result := w.Result()
+	if result.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status - expected: %d\treceived: %d", http.StatusOK, result.StatusCode)
+	}
+	if received := result.Header.Get("Test-Header"); received != "PASS" {
+		t.Fatalf("wrong header: Test-Header - expected: PASS\treceived: %s", received)
+	}
+	if received := result.Header.Get("Other-Header"); received != "" {
+		t.Fatalf("unexpected header: Other-Header: %s", received)
+	}
+	if body := w.Body.String(); !strings.HasPrefix(body, "[PASS]") {
+		t.Fatalf("wrong body - expected: [PASS]\treceived: %q", body)
+	}
+}
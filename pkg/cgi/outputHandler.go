@@ -8,20 +8,56 @@ import (
 	"strings"
 )
 
-// OutputHandler should handle reading in the client CGI process' output from stdoutRead and
+// OutputHandler should handle reading in the client CGI process' output and
 // write out the response to the HTTP client.
-// By the time OutputHandler is called, the client CGI process will have already been started and will
-// be killed right after OutputHandler returns.
+// By the time HandleOutput is called, the client CGI process will have already been started and will
+// be killed right after HandleOutput returns.
+// The Execution gives access to the client process' stdout as well as its exit status, stderr, pid, start time and environment.
 //
 // The client CGI process does not need to provide any headers, Handler will provide default Header values.
-// If the executable does provide header values, they will overwrite the default values in Header.
+// If the executable does provide header values, they will be merged with the default values in Header according to HeaderPolicy.
 // Currently ignored headers: "Location"
-type OutputHandler func(w http.ResponseWriter, r *http.Request,
+type OutputHandler interface {
+	HandleOutput(w http.ResponseWriter, r *http.Request, h *Handler, x *Execution)
+}
+
+// OutputHandlerFunc adapts a function that only needs the client process' stdout into an OutputHandler.
+type OutputHandlerFunc func(w http.ResponseWriter, r *http.Request,
 	h *Handler, stdoutReader io.Reader)
 
+// HandleOutput calls f with the stdout of x.
+func (f OutputHandlerFunc) HandleOutput(w http.ResponseWriter, r *http.Request, h *Handler, x *Execution) {
+	f(w, r, h, x.Stdout)
+}
+
+// executionHandlerFunc adapts a function into an OutputHandler.
+type executionHandlerFunc func(w http.ResponseWriter, r *http.Request, h *Handler, x *Execution)
+
+func (f executionHandlerFunc) HandleOutput(w http.ResponseWriter, r *http.Request, h *Handler, x *Execution) {
+	f(w, r, h, x)
+}
+
+// writeTrailers sets the values the client process wrote for the trailers declared in the response header,
+// they are sent to the HTTP client once the handler returns.
+func writeTrailers(w http.ResponseWriter, x *Execution) {
+	declared := w.Header()["Trailer"]
+	if len(declared) == 0 {
+		return
+	}
+	trailer := x.Trailer()
+	for _, d := range declared {
+		for _, k := range strings.Split(d, ",") {
+			k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+			if vv, ok := trailer[k]; ok {
+				w.Header()[k] = vv
+			}
+		}
+	}
+}
+
 // EZOutputHandler sends the entire output of the client process without scanning for headers.
 // Always responds with a 200 status code.
-var EZOutputHandler OutputHandler = func(w http.ResponseWriter, r *http.Request, h *Handler, stdoutRead io.Reader) {
+var EZOutputHandler OutputHandler = OutputHandlerFunc(func(w http.ResponseWriter, r *http.Request, h *Handler, stdoutRead io.Reader) {
 	for k, vv := range h.Header {
 		for _, v := range vv {
 			w.Header().Add(k, v)
@@ -35,12 +71,14 @@ var EZOutputHandler OutputHandler = func(w http.ResponseWriter, r *http.Request,
 		h.logErr("cgi: copy error: %v", err)
 		return
 	}
-}
+})
 
-// EZOutputHandlerReplacer scans the output of the client process for headers which replaces the default header values.
+// EZOutputHandlerReplacer scans the output of the client process for headers which are merged with the default header values
+// according to the Handler's HeaderPolicy.
 // Stops scanning for headers after encountering the first non-header line.
 // The rest of the output is then sent as the response body.
-var EZOutputHandlerReplacer OutputHandler = func(w http.ResponseWriter, r *http.Request, h *Handler, stdoutRead io.Reader) {
+// If the Handler has Trailers enabled, the trailers declared by the client process are sent after the body.
+var EZOutputHandlerReplacer OutputHandler = executionHandlerFunc(func(w http.ResponseWriter, r *http.Request, h *Handler, x *Execution) {
 	internalError := func(err error) {
 		w.WriteHeader(http.StatusInternalServerError)
 		h.logErr("CGI error: %v", err)
@@ -49,12 +87,22 @@ var EZOutputHandlerReplacer OutputHandler = func(w http.ResponseWriter, r *http.
 	// readBytes holds the bytes read during header scan but that aren't part of the header.
 	// This data will be added to the front of the responses body
 	var readBytes []byte
-	linebody := bufio.NewReaderSize(stdoutRead, 1024)
+	linebody := bufio.NewReaderSize(x.Stdout, 1024)
+	headerLines := h.newHeaderReader(linebody)
 	statusCode := 0
+	header := h.newHeaderMerger()
 
 	for {
-		line, tooBig, err := linebody.ReadLine()
-		if tooBig || err == io.EOF {
+		line, complete, err := headerLines.readLine()
+		if err == errHeaderTooLarge {
+			// This line is too long to be a header, add it to the head of the body and break
+			readBytes = line
+			if complete {
+				readBytes = append(readBytes, '\n')
+			}
+			break
+		}
+		if err == io.EOF {
 			break
 		}
 		if err != nil {
@@ -68,7 +116,6 @@ var EZOutputHandlerReplacer OutputHandler = func(w http.ResponseWriter, r *http.
 		parts := strings.SplitN(string(line), ":", 2)
 		if len(parts) < 2 {
 			// This line is not a header, add it to the head of the body and break
-			readBytes = line
 			readBytes = append(line, '\n')
 			break
 		}
@@ -90,18 +137,14 @@ var EZOutputHandlerReplacer OutputHandler = func(w http.ResponseWriter, r *http.
 			}
 			statusCode = code
 		default:
-			h.Header.Set(k, v)
+			header.add(k, v)
 		}
 	}
 	if statusCode == 0 {
 		statusCode = http.StatusOK
 	}
 
-	for k, vv := range h.Header {
-		for _, v := range vv {
-			w.Header().Add(k, v)
-		}
-	}
+	header.writeTo(w)
 	w.WriteHeader(statusCode)
 
 	// Add back in the beginning portion of the body that was slurped up while scanning for headers.
@@ -118,23 +161,27 @@ var EZOutputHandlerReplacer OutputHandler = func(w http.ResponseWriter, r *http.
 		h.logErr("cgi: copy error: %v", err)
 		return
 	}
-}
+	writeTrailers(w, x)
+})
 
 // DefaultOutputHandler *mostly* mimics the behavior of the net/http/cgi package in the Go standard library.
 // The only difference is DefaultOutputHandler does not call on the PathLocationHandler function found in the standard library.
+// The headers written by the client process are merged with the default header values according to the Handler's HeaderPolicy.
+// If the Handler has Trailers enabled, the trailers declared by the client process are sent after the body.
 // Currently ignored headers: "Location"
-var DefaultOutputHandler OutputHandler = func(w http.ResponseWriter, r *http.Request,
-	h *Handler, stdoutRead io.Reader) {
-	linebody := bufio.NewReaderSize(stdoutRead, 1024)
+var DefaultOutputHandler OutputHandler = executionHandlerFunc(func(w http.ResponseWriter, r *http.Request,
+	h *Handler, x *Execution) {
+	linebody := bufio.NewReaderSize(x.Stdout, 1024)
+	headerReader := h.newHeaderReader(linebody)
 	headers := make(http.Header)
 	statusCode := 0
 	headerLines := 0
 	sawBlankLine := false
 	for {
-		line, isPrefix, err := linebody.ReadLine()
-		if isPrefix {
+		line, _, err := headerReader.readLine()
+		if err == errHeaderTooLarge {
 			w.WriteHeader(http.StatusInternalServerError)
-			h.logErr("cgi: long header line from subprocess.")
+			h.logErr("cgi: header block from subprocess is too large.")
 			return
 		}
 		if err == io.EOF {
@@ -197,16 +244,19 @@ var DefaultOutputHandler OutputHandler = func(w http.ResponseWriter, r *http.Req
 		statusCode = http.StatusOK
 	}
 
+	merged := h.newHeaderMerger()
 	for k, vv := range headers {
 		for _, v := range vv {
-			w.Header().Add(k, v)
+			merged.add(k, v)
 		}
 	}
-
+	merged.writeTo(w)
 	w.WriteHeader(statusCode)
 
 	_, err := io.Copy(w, linebody)
 	if err != nil {
 		h.logErr("cgi: copy error: %v", err)
+		return
 	}
-}
+	writeTrailers(w, x)
+})
@@ -0,0 +1,129 @@
+package cgi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pattern is a URL path pattern whose segments are either literal or named parameters, such as /users/{id}/reports/{name}.
+// A pattern matches the paths that start with segments matching its own, the rest of the path is left for PATH_INFO.
+type Pattern struct {
+	raw      string
+	segments []patternSegment
+	params   []string
+}
+
+type patternSegment struct {
+	literal string
+	// param is the name of the parameter if the segment is one, empty for literal segments.
+	param string
+}
+
+// ParsePattern parses a URL path pattern, it must start with a "/".
+// Parameters take up whole segments and are named with letters, digits and underscores.
+func ParsePattern(s string) (*Pattern, error) {
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("cgi: invalid pattern %q: must start with a '/'", s)
+	}
+
+	p := &Pattern{raw: s}
+	envNames := make(map[string]string)
+	trimmed := strings.Trim(s, "/")
+	if trimmed == "" {
+		return p, nil
+	}
+	for _, seg := range strings.Split(trimmed, "/") {
+		if seg == "" {
+			return nil, fmt.Errorf("cgi: invalid pattern %q: empty segment", s)
+		}
+		if !strings.ContainsAny(seg, "{}") {
+			p.segments = append(p.segments, patternSegment{literal: seg})
+			continue
+		}
+
+		if !strings.HasPrefix(seg, "{") || !strings.HasSuffix(seg, "}") {
+			return nil, fmt.Errorf("cgi: invalid pattern %q: parameter %q must take up a whole segment", s, seg)
+		}
+		name := seg[1 : len(seg)-1]
+		if name == "" || strings.IndexFunc(name, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_')
+		}) != -1 {
+			return nil, fmt.Errorf("cgi: invalid pattern %q: invalid parameter name %q", s, name)
+		}
+		// Parameters must stay distinct once turned into environment variables.
+		if other, ok := envNames[envVarName(name)]; ok {
+			return nil, fmt.Errorf("cgi: invalid pattern %q: parameters %q and %q collide", s, other, name)
+		}
+		envNames[envVarName(name)] = name
+		p.segments = append(p.segments, patternSegment{param: name})
+		p.params = append(p.params, name)
+	}
+	return p, nil
+}
+
+func (p *Pattern) String() string {
+	return p.raw
+}
+
+// Params returns the names of the pattern's parameters, in the order they appear in.
+func (p *Pattern) Params() []string {
+	return append([]string(nil), p.params...)
+}
+
+// Match reports whether path starts with segments matching the pattern's.
+// If it does, the values of the pattern's parameters are returned in the order they appear in, along with the rest of path.
+func (p *Pattern) Match(path string) ([]string, string, bool) {
+	var values []string
+	rest := path
+	for _, seg := range p.segments {
+		if !strings.HasPrefix(rest, "/") {
+			return nil, "", false
+		}
+		rest = rest[1:]
+		end := strings.IndexByte(rest, '/')
+		if end == -1 {
+			end = len(rest)
+		}
+		v := rest[:end]
+		rest = rest[end:]
+
+		switch {
+		case seg.param == "" && v != seg.literal:
+			return nil, "", false
+		case seg.param != "" && v == "":
+			return nil, "", false
+		case seg.param != "":
+			values = append(values, v)
+		}
+	}
+	return values, rest, true
+}
+
+// Conflicts reports whether p and q match exactly the same paths, so that neither can be preferred over the other.
+func (p *Pattern) Conflicts(q *Pattern) bool {
+	if len(p.segments) != len(q.segments) {
+		return false
+	}
+	for i, s := range p.segments {
+		t := q.segments[i]
+		if (s.param == "") != (t.param == "") || s.literal != t.literal {
+			return false
+		}
+	}
+	return true
+}
+
+// Precedes reports whether p is more specific than q, and so should be preferred when both match a path.
+// Longer patterns are more specific, patterns of the same length are compared segment by segment with literal segments winning over parameters.
+func (p *Pattern) Precedes(q *Pattern) bool {
+	if len(p.segments) != len(q.segments) {
+		return len(p.segments) > len(q.segments)
+	}
+	for i, s := range p.segments {
+		t := q.segments[i]
+		if (s.param == "") != (t.param == "") {
+			return s.param == ""
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package cgi
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParsePattern(t *testing.T) {
+	valid := map[string][]string{
+		"/":                                  nil,
+		"/users":                             nil,
+		"/users/{id}":                        []string{"id"},
+		"/users/{id}/reports/{report_name}/": []string{"id", "report_name"},
+	}
+	for s, params := range valid {
+		p, err := ParsePattern(s)
+		if err != nil {
+			t.Fatalf("error parsing %q: %s", s, err)
+		}
+		if !reflect.DeepEqual(p.Params(), params) {
+			t.Fatalf("wrong params for %q - expected: %v\treceived: %v", s, params, p.Params())
+		}
+	}
+
+	for _, s := range []string{"users/{id}", "/users//{id}", "/users/{}", "/users/id-{id}", "/users/{i-d}", "/{id}/{ID}"} {
+		if _, err := ParsePattern(s); err == nil {
+			t.Fatalf("expected error parsing %q", s)
+		}
+	}
+}
+
+func TestPatternMatch(t *testing.T) {
+	p, err := ParsePattern("/users/{id}/reports/{name}")
+	if err != nil {
+		t.Fatalf("error parsing pattern: %s", err)
+	}
+
+	type test struct {
+		Path           string
+		ExpectedValues []string
+		ExpectedRest   string
+		ExpectedOK     bool
+	}
+
+	tt := []test{
+		test{Path: "/users/42/reports/daily", ExpectedValues: []string{"42", "daily"}, ExpectedOK: true},
+		test{Path: "/users/42/reports/daily/", ExpectedValues: []string{"42", "daily"}, ExpectedRest: "/", ExpectedOK: true},
+		test{Path: "/users/42/reports/daily/csv", ExpectedValues: []string{"42", "daily"}, ExpectedRest: "/csv", ExpectedOK: true},
+		test{Path: "/users/42/reports"},
+		test{Path: "/users/42/reports/"},
+		test{Path: "/users//reports/daily"},
+		test{Path: "/groups/42/reports/daily"},
+	}
+
+	for _, tc := range tt {
+		values, rest, ok := p.Match(tc.Path)
+		if ok != tc.ExpectedOK || !reflect.DeepEqual(values, tc.ExpectedValues) || rest != tc.ExpectedRest {
+			t.Fatalf("wrong match for %q - expected: %v %q %v\treceived: %v %q %v",
+				tc.Path, tc.ExpectedValues, tc.ExpectedRest, tc.ExpectedOK, values, rest, ok)
+		}
+	}
+}
+
+func TestPatternOrder(t *testing.T) {
+	parse := func(s string) *Pattern {
+		p, err := ParsePattern(s)
+		if err != nil {
+			t.Fatalf("error parsing %q: %s", s, err)
+		}
+		return p
+	}
+
+	conflicts := [][2]string{
+		{"/users/{id}", "/users/{name}"},
+		{"/users", "/users/"},
+	}
+	for _, c := range conflicts {
+		if !parse(c[0]).Conflicts(parse(c[1])) {
+			t.Fatalf("expected %s to conflict with %s", c[0], c[1])
+		}
+	}
+
+	precedes := [][2]string{
+		{"/users/me", "/users/{id}"},
+		{"/users/{id}/reports", "/users/{id}"},
+		{"/users/{id}", "/"},
+		{"/{a}/b", "/{a}/{b}"},
+	}
+	for _, c := range precedes {
+		p, q := parse(c[0]), parse(c[1])
+		if p.Conflicts(q) || !p.Precedes(q) || q.Precedes(p) {
+			t.Fatalf("expected %s to precede %s", c[0], c[1])
+		}
+	}
+}
+
+func TestPathParams(t *testing.T) {
+	h, err := New("./params.sh",
+		WithDir("."),
+		WithOutputHandler(EZOutputHandler),
+		WithRoot("/users/{id}/reports/{report_name}"),
+		WithParamArgs(),
+		WithArgs("--"),
+	)
+	if err != nil {
+		t.Fatalf("error while creating handler: %s", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/users/42/reports/daily/csv", nil))
+	expected := "/users/42/reports/daily /csv 42 daily -- 42 daily\n"
+	if w.Body.String() != expected {
+		t.Fatalf("wrong body - expected: %q\treceived: %q", expected, w.Body.String())
+	}
+}
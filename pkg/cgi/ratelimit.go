@@ -0,0 +1,108 @@
+package cgi
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitKey decides what a RateLimiter tells HTTP clients apart by.
+type RateLimitKey int
+
+const (
+	// RateLimitByIP limits each client IP address, as seen in REMOTE_ADDR.
+	RateLimitByIP RateLimitKey = iota
+	// RateLimitByUser limits each authenticated user, unauthenticated requests are limited by IP address.
+	RateLimitByUser
+	// RateLimitByHeader limits each value of RateLimiter.Header, requests without it are limited by IP address.
+	RateLimitByHeader
+)
+
+// rateLimitSweepInterval is how often a RateLimiter forgets about clients that have been idle long enough to have a full bucket.
+const rateLimitSweepInterval = time.Minute
+
+// RateLimiter limits how often each HTTP client can have the executable run, using a token bucket per client.
+// Each client's bucket holds up to Burst requests and is refilled at Rate requests per second.
+// Requests made while the bucket is empty are responded to with a 429 and a Retry-After header.
+//
+// A RateLimiter must not be copied after first use.
+type RateLimiter struct {
+	// Rate is how many requests per second each client's bucket is refilled with.
+	Rate float64
+	// Burst is how many requests each client's bucket holds.
+	Burst int
+	// Key decides what clients are told apart by.
+	// Defaults to RateLimitByIP.
+	Key RateLimitKey
+	// Header is the header clients are told apart by when Key is RateLimitByHeader.
+	Header string
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// key returns the key the request r is limited by.
+func (l *RateLimiter) key(h *Handler, r *http.Request) string {
+	switch l.Key {
+	case RateLimitByUser:
+		if id := RequestIdentity(r); id != nil && id.User != "" {
+			return "user:" + id.User
+		}
+	case RateLimitByHeader:
+		if v := r.Header.Get(l.Header); v != "" {
+			return "header:" + v
+		}
+	}
+	ip, _ := h.remoteAddr(r)
+	return "ip:" + ip
+}
+
+// take takes a token from the bucket for key at time now.
+// If the bucket is empty it returns false along with how long until a token will be available.
+func (l *RateLimiter) take(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	burst := float64(l.Burst)
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+		l.swept = now
+	}
+	if now.Sub(l.swept) >= rateLimitSweepInterval {
+		// Buckets that would be full by now hold no information, forget about them to keep memory bounded.
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.Rate >= burst {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// tooManyRequests responds to the HTTP client with a 429, telling it to retry after wait.
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
@@ -0,0 +1,172 @@
+package cgi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := &RateLimiter{Rate: 2, Burst: 3}
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.take("a", now); !ok {
+			t.Fatalf("request %d within burst limited", i)
+		}
+	}
+	ok, wait := l.take("a", now)
+	if ok {
+		t.Fatal("request past burst not limited")
+	}
+	if wait != 500*time.Millisecond {
+		t.Fatalf("wrong wait - expected: %s\treceived: %s", 500*time.Millisecond, wait)
+	}
+	if ok, _ := l.take("b", now); !ok {
+		t.Fatal("other key limited")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.take("a", now); !ok {
+		t.Fatal("request after refill limited")
+	}
+	if ok, _ := l.take("a", now); ok {
+		t.Fatal("request past refill not limited")
+	}
+
+	// Idle keys are forgotten once their bucket would be full.
+	now = now.Add(rateLimitSweepInterval)
+	l.take("c", now)
+	if len(l.buckets) != 1 {
+		t.Fatalf("idle buckets not swept: %d buckets left", len(l.buckets))
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	h, err := New("./remoteaddr.sh",
+		WithDir("."),
+		WithOutputHandler(EZOutputHandler),
+		WithRateLimit(&RateLimiter{Rate: 0.1, Burst: 2, Key: RateLimitByHeader, Header: "X-Api-Key"}),
+	)
+	if err != nil {
+		t.Fatalf("error while creating handler: %s", err)
+	}
+
+	type test struct {
+		Name           string
+		Key            string
+		ExpectedStatus int
+	}
+
+	tt := []test{
+		test{Name: "First", Key: "a", ExpectedStatus: http.StatusOK},
+		test{Name: "Burst", Key: "a", ExpectedStatus: http.StatusOK},
+		test{Name: "Limited", Key: "a", ExpectedStatus: http.StatusTooManyRequests},
+		test{Name: "Other key", Key: "b", ExpectedStatus: http.StatusOK},
+		test{Name: "No key", ExpectedStatus: http.StatusOK},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tc.Key != "" {
+				r.Header.Set("X-Api-Key", tc.Key)
+			}
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+
+			if w.Code != tc.ExpectedStatus {
+				t.Fatalf("wrong status - expected: %d\treceived: %d", tc.ExpectedStatus, w.Code)
+			}
+			if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "10" {
+				t.Fatalf("wrong Retry-After - expected: 10\treceived: %s", w.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestRateLimitAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ez-cgi-test")
+	if err != nil {
+		t.Fatalf("error while creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	htpasswd := filepath.Join(dir, "htpasswd")
+	if err := ioutil.WriteFile(htpasswd, []byte("sha:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"), 0600); err != nil {
+		t.Fatalf("error while writing htpasswd file: %s", err)
+	}
+	auth, err := NewHtpasswdAuth(htpasswd, "test")
+	if err != nil {
+		t.Fatalf("error while loading htpasswd file: %s", err)
+	}
+
+	type request struct {
+		Password       string
+		ExpectedStatus int
+	}
+	type test struct {
+		Name     string
+		Key      RateLimitKey
+		Requests []request
+	}
+
+	tt := []test{
+		test{
+			// Failed authentication attempts use up the client's tokens.
+			Name: "By IP",
+			Key:  RateLimitByIP,
+			Requests: []request{
+				{Password: "wrong", ExpectedStatus: http.StatusUnauthorized},
+				{Password: "wrong", ExpectedStatus: http.StatusUnauthorized},
+				{Password: "wrong", ExpectedStatus: http.StatusTooManyRequests},
+				{Password: "wrong", ExpectedStatus: http.StatusTooManyRequests},
+				{Password: "password", ExpectedStatus: http.StatusTooManyRequests},
+			},
+		},
+		test{
+			// The user is only known once authenticated, so only authenticated requests are counted.
+			Name: "By user",
+			Key:  RateLimitByUser,
+			Requests: []request{
+				{Password: "wrong", ExpectedStatus: http.StatusUnauthorized},
+				{Password: "wrong", ExpectedStatus: http.StatusUnauthorized},
+				{Password: "wrong", ExpectedStatus: http.StatusUnauthorized},
+				{Password: "password", ExpectedStatus: http.StatusOK},
+				{Password: "password", ExpectedStatus: http.StatusOK},
+				{Password: "password", ExpectedStatus: http.StatusTooManyRequests},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			h, err := New("./remoteuser.sh",
+				WithDir("."),
+				WithOutputHandler(EZOutputHandler),
+				WithAuth(auth),
+				WithRateLimit(&RateLimiter{Rate: 0.1, Burst: 2, Key: tc.Key}),
+			)
+			if err != nil {
+				t.Fatalf("error while creating handler: %s", err)
+			}
+
+			for i, req := range tc.Requests {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.SetBasicAuth("sha", req.Password)
+				w := httptest.NewRecorder()
+
+				h.ServeHTTP(w, r)
+
+				if w.Code != req.ExpectedStatus {
+					t.Fatalf("wrong status for request %d - expected: %d\treceived: %d", i, req.ExpectedStatus, w.Code)
+				}
+			}
+		})
+	}
+}
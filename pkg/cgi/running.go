@@ -0,0 +1,91 @@
+package cgi
+
+import (
+	"context"
+	"os"
+	"sync"
+)
+
+// running keeps track of the executions of a Handler that are in flight, from when they're started until they're closed.
+type running struct {
+	mu sync.Mutex
+	xs map[*Execution]struct{}
+	// idle is closed once there are no executions left in flight.
+	idle chan struct{}
+}
+
+func newRunning() *running {
+	idle := make(chan struct{})
+	close(idle)
+	return &running{xs: make(map[*Execution]struct{}), idle: idle}
+}
+
+func (rn *running) add(x *Execution) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	if len(rn.xs) == 0 {
+		rn.idle = make(chan struct{})
+	}
+	rn.xs[x] = struct{}{}
+}
+
+func (rn *running) remove(x *Execution) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	if _, ok := rn.xs[x]; !ok {
+		return
+	}
+	delete(rn.xs, x)
+	if len(rn.xs) == 0 {
+		close(rn.idle)
+	}
+}
+
+// Wait blocks until none of h's executions are in flight or ctx is done, in which case ctx's error is returned.
+// An execution is in flight from when its client process is started until its request has been fully handled,
+// so requests that are still being admitted or are waiting on their request body aren't waited on.
+// Only Handlers created with New keep track of their executions, Wait returns immediately for any other Handler.
+func (h *Handler) Wait(ctx context.Context) error {
+	rn := h.running
+	if rn == nil {
+		return nil
+	}
+	for {
+		rn.mu.Lock()
+		if len(rn.xs) == 0 {
+			rn.mu.Unlock()
+			return nil
+		}
+		idle := rn.idle
+		rn.mu.Unlock()
+
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Signal sends sig to the client processes of every execution of h in flight.
+// It returns how many executions were signaled.
+func (h *Handler) Signal(sig os.Signal) int {
+	rn := h.running
+	if rn == nil {
+		return 0
+	}
+	rn.mu.Lock()
+	xs := make([]*Execution, 0, len(rn.xs))
+	for x := range rn.xs {
+		xs = append(xs, x)
+	}
+	rn.mu.Unlock()
+
+	n := 0
+	for _, x := range xs {
+		if x.Signal(sig) == nil {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,117 @@
+package cgi
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultSSEKeepAlive is how often SSEOutputHandler sends a keepalive comment to the HTTP client.
+const DefaultSSEKeepAlive = 15 * time.Second
+
+// SSEOutputHandler streams the output of the client process to the HTTP client as Server-Sent Events.
+// Each line of output is sent as the data of its own event.
+// See NewSSEOutputHandler for details.
+var SSEOutputHandler OutputHandler = NewSSEOutputHandler(DefaultSSEKeepAlive)
+
+// NewSSEOutputHandler returns an OutputHandler that streams the output of the client process as Server-Sent Events.
+// Each line of output is sent as the data of its own event.
+// Lines starting with "event:" or "id:" set the event type or id of the next event instead of being sent as data.
+// A keepalive comment is sent every keepAlive; a keepAlive of zero disables keepalive comments.
+// The client process is killed as soon as the HTTP client disconnects.
+//
+// The Content-Type header is always set to "text/event-stream", all other default header values are sent as is.
+func NewSSEOutputHandler(keepAlive time.Duration) OutputHandler {
+	return OutputHandlerFunc(func(w http.ResponseWriter, r *http.Request, h *Handler, stdoutRead io.Reader) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			h.logErr("cgi: response writer does not support flushing")
+			return
+		}
+
+		for k, vv := range h.Header {
+			if k == "Content-Type" {
+				continue
+			}
+			for _, v := range vv {
+				w.Header().Add(k, v)
+			}
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		// Lines are read in their own goroutine so that we can keep an eye on the HTTP client while waiting for output.
+		lines := make(chan string)
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			defer close(lines)
+			linebody := bufio.NewReaderSize(stdoutRead, 1024)
+			for {
+				line, err := linebody.ReadString('\n')
+				if line != "" {
+					select {
+					case lines <- strings.TrimRight(line, "\r\n"):
+					case <-done:
+						return
+					}
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+
+		var tick <-chan time.Time
+		if keepAlive > 0 {
+			ticker := time.NewTicker(keepAlive)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		var event, id string
+		for {
+			var err error
+			select {
+			case <-r.Context().Done():
+				// The client has gone away, returning lets Handler kill the process.
+				return
+			case <-tick:
+				_, err = io.WriteString(w, ": keepalive\n\n")
+			case line, ok := <-lines:
+				if !ok {
+					return
+				}
+				switch {
+				case strings.HasPrefix(line, "event:"):
+					event = strings.TrimSpace(line[len("event:"):])
+					continue
+				case strings.HasPrefix(line, "id:"):
+					id = strings.TrimSpace(line[len("id:"):])
+					continue
+				}
+				if event != "" {
+					_, err = fmt.Fprintf(w, "event: %s\n", event)
+				}
+				if err == nil && id != "" {
+					_, err = fmt.Fprintf(w, "id: %s\n", id)
+				}
+				if err == nil {
+					_, err = fmt.Fprintf(w, "data: %s\n\n", line)
+				}
+				event, id = "", ""
+			}
+			if err != nil {
+				h.logErr("cgi: write error: %v", err)
+				return
+			}
+			flusher.Flush()
+		}
+	})
+}
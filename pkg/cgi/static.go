@@ -0,0 +1,173 @@
+package cgi
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// StaticHandler serves the files in a directory alongside an executable, such as the HTML, JavaScript and CSS of the page it backs.
+// Files are served with http.ServeContent, which takes care of Last-Modified, ETag, conditional and range requests.
+// Directories are served through their index file, there are no directory listings.
+//
+// Only GET and HEAD requests are served files, every other request goes straight to Handler.
+type StaticHandler struct {
+	// Dir is the directory files are served from.
+	Dir string
+	// Root is the path prefix the StaticHandler is mounted at, it's stripped from the request path to find the file to serve.
+	// Defaults to "/".
+	Root string
+	// Index lists the names of the files served for a directory, the first one found is served.
+	// Defaults to "index.html".
+	Index []string
+
+	// Handler, usually a *Handler, handles the requests that aren't for a static file.
+	// If nil, those requests are responded to with a 404.
+	Handler http.Handler
+	// HandlerFirst gives Handler precedence over static files:
+	// requests are handled by Handler first, and a static file is only served if Handler responds with a 404.
+	// By default static files are served first, and Handler only handles requests for which there's no file.
+	HandlerFirst bool
+}
+
+func (sh *StaticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, fi := sh.lookup(r)
+	if fi == nil {
+		sh.next(w, r)
+		return
+	}
+	if fi.IsDir() && !strings.HasSuffix(r.URL.Path, "/") {
+		// Relative links in the index file only work if the directory is requested with a trailing slash.
+		u := *r.URL
+		u.Path += "/"
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+		return
+	}
+
+	if sh.HandlerFirst && sh.Handler != nil {
+		nf := &notFoundWriter{ResponseWriter: w, header: make(http.Header)}
+		sh.Handler.ServeHTTP(nf, r)
+		if !nf.notFound {
+			return
+		}
+	}
+	serveFile(w, r, name)
+}
+
+// lookup returns the name and info of the file that should be served for r, or nil info if there isn't one.
+// Directories are resolved to their index file, their own info is returned so that the caller can tell they're directories.
+func (sh *StaticHandler) lookup(r *http.Request) (string, os.FileInfo) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return "", nil
+	}
+
+	// Root only matches whole path segments, "/assets" serves "/assets/app.js" but not "/assetsapp.js".
+	root := strings.TrimSuffix(sh.Root, "/")
+	p := r.URL.Path
+	if p != root && !strings.HasPrefix(p, root+"/") {
+		return "", nil
+	}
+	p = path.Clean("/" + strings.TrimPrefix(p, root))
+	name := filepath.Join(sh.Dir, filepath.FromSlash(p))
+
+	fi, err := os.Stat(name)
+	if err != nil {
+		return "", nil
+	}
+	if !fi.IsDir() {
+		return name, fi
+	}
+
+	index := sh.Index
+	if len(index) == 0 {
+		index = []string{"index.html"}
+	}
+	for _, idx := range index {
+		if ifi, err := os.Stat(filepath.Join(name, idx)); err == nil && !ifi.IsDir() {
+			return filepath.Join(name, idx), fi
+		}
+	}
+	return "", nil
+}
+
+func (sh *StaticHandler) next(w http.ResponseWriter, r *http.Request) {
+	if sh.Handler == nil {
+		http.NotFound(w, r)
+		return
+	}
+	sh.Handler.ServeHTTP(w, r)
+}
+
+// serveFile serves the file name with a weak ETag derived from its size and modification time.
+func serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	f, err := os.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("ETag", fmt.Sprintf(`W/"%x-%x"`, fi.Size(), fi.ModTime().UnixNano()))
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+// notFoundWriter passes a response through to ResponseWriter unless its status is 404, in which case it's discarded.
+type notFoundWriter struct {
+	http.ResponseWriter
+	// header holds the headers until the status is known, after that the ResponseWriter's own headers are used.
+	header      http.Header
+	wroteHeader bool
+	notFound    bool
+}
+
+func (nf *notFoundWriter) Header() http.Header {
+	if nf.wroteHeader && !nf.notFound {
+		return nf.ResponseWriter.Header()
+	}
+	return nf.header
+}
+
+func (nf *notFoundWriter) WriteHeader(code int) {
+	if nf.wroteHeader {
+		return
+	}
+	nf.wroteHeader = true
+	if code == http.StatusNotFound {
+		nf.notFound = true
+		return
+	}
+	h := nf.ResponseWriter.Header()
+	for k, v := range nf.header {
+		h[k] = v
+	}
+	nf.ResponseWriter.WriteHeader(code)
+}
+
+func (nf *notFoundWriter) Write(p []byte) (int, error) {
+	if !nf.wroteHeader {
+		nf.WriteHeader(http.StatusOK)
+	}
+	if nf.notFound {
+		return len(p), nil
+	}
+	return nf.ResponseWriter.Write(p)
+}
+
+func (nf *notFoundWriter) Flush() {
+	if nf.notFound {
+		return
+	}
+	if !nf.wroteHeader {
+		nf.WriteHeader(http.StatusOK)
+	}
+	if f, ok := nf.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
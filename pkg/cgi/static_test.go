@@ -0,0 +1,172 @@
+package cgi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStaticHandler(t *testing.T) {
+	// script stands in for a Handler, it only knows about /assets/app.js and /assets/script.
+	script := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/assets/app.js", "/assets/script":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("SCRIPT\n"))
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("script not found\n"))
+		}
+	})
+
+	type test struct {
+		Name           string
+		Method         string
+		Path           string
+		Header         http.Header
+		HandlerFirst   bool
+		ExpectedStatus int
+		ExpectedHeader http.Header
+		ExpectedBody   string
+	}
+
+	tt := []test{
+		test{
+			Name:           "File",
+			Path:           "/assets/app.js",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   "console.log(\"PASS\");\n",
+		},
+		test{
+			Name:           "Index",
+			Path:           "/assets/",
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{"Content-Type": []string{"text/html; charset=utf-8"}},
+			ExpectedBody:   "<h1>PASS</h1>\n",
+		},
+		test{
+			Name:           "Directory without trailing slash",
+			Path:           "/assets/docs",
+			ExpectedStatus: http.StatusMovedPermanently,
+			ExpectedHeader: http.Header{"Location": []string{"/assets/docs/"}},
+		},
+		test{
+			Name:           "Directory without index",
+			Path:           "/assets/noindex/",
+			ExpectedStatus: http.StatusNotFound,
+			ExpectedBody:   "script not found\n",
+		},
+		test{
+			Name:           "Range",
+			Path:           "/assets/app.js",
+			Header:         http.Header{"Range": []string{"bytes=0-10"}},
+			ExpectedStatus: http.StatusPartialContent,
+			ExpectedHeader: http.Header{"Content-Range": []string{"bytes 0-10/21"}},
+			ExpectedBody:   "console.log",
+		},
+		test{
+			Name:           "Not a file",
+			Path:           "/assets/script",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   "SCRIPT\n",
+		},
+		test{
+			Name:           "Path traversal",
+			Path:           "/assets/../echo.sh",
+			ExpectedStatus: http.StatusNotFound,
+			ExpectedBody:   "script not found\n",
+		},
+		test{
+			Name:           "Sibling of the root",
+			Path:           "/assetsapp.js",
+			ExpectedStatus: http.StatusNotFound,
+			ExpectedBody:   "script not found\n",
+		},
+		test{
+			Name:           "POST goes to the script",
+			Method:         "POST",
+			Path:           "/assets/app.js",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   "SCRIPT\n",
+		},
+		test{
+			Name:           "Script first",
+			Path:           "/assets/app.js",
+			HandlerFirst:   true,
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   "SCRIPT\n",
+		},
+		test{
+			Name:           "Script first falls back to file",
+			Path:           "/assets/",
+			HandlerFirst:   true,
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{"Content-Type": []string{"text/html; charset=utf-8"}},
+			ExpectedBody:   "<h1>PASS</h1>\n",
+		},
+	}
+
+	for _, tc := range tt {
+		sh := &StaticHandler{
+			Dir:          "static",
+			Root:         "/assets",
+			Handler:      script,
+			HandlerFirst: tc.HandlerFirst,
+		}
+		method := tc.Method
+		if method == "" {
+			method = "GET"
+		}
+		r := httptest.NewRequest(method, tc.Path, nil)
+		for k, v := range tc.Header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		sh.ServeHTTP(w, r)
+
+		if w.Code != tc.ExpectedStatus {
+			t.Fatalf("%s: wrong status - expected: %d\treceived: %d", tc.Name, tc.ExpectedStatus, w.Code)
+		}
+		for k, v := range tc.ExpectedHeader {
+			if got := w.Header().Get(k); got != v[0] {
+				t.Fatalf("%s: wrong %s header - expected: %q\treceived: %q", tc.Name, k, v[0], got)
+			}
+		}
+		if tc.ExpectedBody != "" && w.Body.String() != tc.ExpectedBody {
+			t.Fatalf("%s: wrong body - expected: %q\treceived: %q", tc.Name, tc.ExpectedBody, w.Body.String())
+		}
+	}
+}
+
+func TestStaticHandlerConditional(t *testing.T) {
+	sh := &StaticHandler{Dir: "static"}
+
+	w := httptest.NewRecorder()
+	sh.ServeHTTP(w, httptest.NewRequest("GET", "/app.js", nil))
+	etag := w.Header().Get("ETag")
+	if etag == "" || w.Header().Get("Last-Modified") == "" {
+		t.Fatalf("missing validators: %v", w.Header())
+	}
+
+	for _, h := range []http.Header{
+		http.Header{"If-None-Match": []string{etag}},
+		http.Header{"If-Modified-Since": []string{w.Header().Get("Last-Modified")}},
+	} {
+		r := httptest.NewRequest("GET", "/app.js", nil)
+		for k, v := range h {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		sh.ServeHTTP(w, r)
+		if w.Code != http.StatusNotModified {
+			t.Fatalf("wrong status for %v - expected: %d\treceived: %d", h, http.StatusNotModified, w.Code)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	sh.ServeHTTP(w, httptest.NewRequest("GET", "/missing.js", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("wrong status for missing file - expected: %d\treceived: %d", http.StatusNotFound, w.Code)
+	}
+}
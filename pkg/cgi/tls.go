@@ -0,0 +1,45 @@
+package cgi
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+)
+
+// tlsVersions maps TLS versions to the names mod_ssl uses for them in SSL_PROTOCOL.
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLSv1",
+	tls.VersionTLS11: "TLSv1.1",
+	tls.VersionTLS12: "TLSv1.2",
+	tls.VersionTLS13: "TLSv1.3",
+}
+
+// tlsEnv returns the SSL_* environment variables describing the TLS connection cs, in the same way mod_ssl does.
+func tlsEnv(cs *tls.ConnectionState) []string {
+	protocol, ok := tlsVersions[cs.Version]
+	if !ok {
+		protocol = fmt.Sprintf("0x%04x", cs.Version)
+	}
+	env := []string{
+		"SSL_PROTOCOL=" + protocol,
+		"SSL_CIPHER=" + tls.CipherSuiteName(cs.CipherSuite),
+	}
+
+	if len(cs.PeerCertificates) == 0 {
+		return append(env, "SSL_CLIENT_VERIFY=NONE")
+	}
+
+	// A certificate without a verified chain was requested but not verified against any CA, mod_ssl calls that GENEROUS.
+	verify := "GENEROUS"
+	if len(cs.VerifiedChains) > 0 {
+		verify = "SUCCESS"
+	}
+	cert := cs.PeerCertificates[0]
+	return append(env,
+		"SSL_CLIENT_VERIFY="+verify,
+		"SSL_CLIENT_S_DN="+cert.Subject.String(),
+		"SSL_CLIENT_I_DN="+cert.Issuer.String(),
+		fmt.Sprintf("SSL_CLIENT_SERIAL=%X", cert.SerialNumber),
+		"SSL_CLIENT_CERT="+string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+	)
+}
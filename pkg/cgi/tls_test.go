@@ -0,0 +1,95 @@
+package cgi
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTLSEnv(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error while generating key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(0xbeef),
+		Subject:      pkix.Name{CommonName: "client", Organization: []string{"ez-cgi"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error while creating certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("error while parsing certificate: %s", err)
+	}
+	certPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+
+	h := &Handler{
+		Path:          "./tlsenv.sh",
+		Dir:           ".",
+		OutputHandler: EZOutputHandler,
+	}
+
+	type test struct {
+		Name         string
+		State        *tls.ConnectionState
+		ExpectedBody string
+	}
+
+	tt := []test{
+		test{
+			Name:         "Plain HTTP",
+			ExpectedBody: "   \n\n\n\n\n",
+		},
+		test{
+			Name: "No client certificate",
+			State: &tls.ConnectionState{
+				Version:     tls.VersionTLS12,
+				CipherSuite: tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			},
+			ExpectedBody: "on TLSv1.2 TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 NONE\n\n\n\n\n",
+		},
+		test{
+			Name: "Verified client certificate",
+			State: &tls.ConnectionState{
+				Version:          tls.VersionTLS13,
+				CipherSuite:      tls.TLS_AES_128_GCM_SHA256,
+				PeerCertificates: []*x509.Certificate{cert},
+				VerifiedChains:   [][]*x509.Certificate{{cert}},
+			},
+			ExpectedBody: "on TLSv1.3 TLS_AES_128_GCM_SHA256 SUCCESS\n" +
+				"CN=client,O=ez-cgi\n" +
+				"CN=client,O=ez-cgi\n" +
+				"BEEF\n" +
+				certPEM + "\n",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.TLS = tc.State
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+
+			body, _ := ioutil.ReadAll(w.Result().Body)
+			if string(body) != tc.ExpectedBody {
+				t.Fatalf("wrong body - expected: %q\treceived: %q", tc.ExpectedBody, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package cgi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// uploadExtRegex matches the file extensions kept on spooled files, anything else is dropped.
+var uploadExtRegex = regexp.MustCompile(`^\.[A-Za-z0-9]{1,16}$`)
+
+// Uploads parses multipart/form-data request bodies on behalf of the client process, spooling each uploaded file
+// to a temporary directory that's removed once the request has been handled.
+// The client process is told about the files in environment variables:
+//
+//	UPLOAD_DIR              the directory the files were spooled to
+//	UPLOAD_COUNT            how many files were uploaded
+//	UPLOAD_<N>_FIELD        the form field of the Nth file, starting at 0
+//	UPLOAD_<N>_FILENAME     the file name given by the HTTP client
+//	UPLOAD_<N>_PATH         where the file was spooled to
+//	UPLOAD_<N>_SIZE         the size of the file in bytes
+//	UPLOAD_<N>_TYPE         the content type given by the HTTP client
+//
+// The other form fields are passed on like FormEnv does with form bodies, in FORM_<NAME> variables.
+// Since the request body has been consumed, the client process' stdin is empty.
+//
+// Requests with a malformed body or too many files are responded to with a 400, or a 413 if a file or the form fields are too large,
+// before the client process is started.
+type Uploads struct {
+	// Dir is where the temporary directories are created.
+	// Defaults to the operating system's temporary directory.
+	Dir string
+	// MaxFileSize, if positive, is the maximum size in bytes of a single uploaded file.
+	MaxFileSize int64
+	// MaxFiles, if positive, is the maximum number of files a request may upload.
+	MaxFiles int
+	// MaxFieldsSize is the maximum size in bytes of all the form fields that aren't files.
+	// Defaults to DefaultMaxFormSize.
+	MaxFieldsSize int64
+	// Manifest also describes the uploaded files and form fields in a JSON file, whose path is passed on in UPLOAD_MANIFEST.
+	Manifest bool
+}
+
+// upload is the result of spooling a request's multipart body.
+type upload struct {
+	dir   string
+	env   []string
+	files []uploadedFile
+}
+
+type uploadedFile struct {
+	Field       string `json:"field"`
+	Filename    string `json:"filename"`
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+}
+
+// isMultipartForm reports whether r has a multipart/form-data body.
+func isMultipartForm(r *http.Request) bool {
+	return r.ContentLength != 0 && strings.HasPrefix(strings.ToLower(r.Header.Get("Content-Type")), "multipart/form-data")
+}
+
+// spool reads r's multipart body, spooling its files to a new temporary directory which the caller must remove.
+// The form fields are turned into environment variables according to f, which may be nil.
+// If r should be rejected, the error is returned along with the status code to respond with and nothing is left behind.
+func (u *Uploads) spool(r *http.Request, f *FormEnv) (*upload, int, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("malformed multipart body: %v", err)
+	}
+	dir, err := ioutil.TempDir(u.Dir, "ez-cgi-upload-")
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	up := &upload{dir: dir}
+	fail := func(code int, err error) (*upload, int, error) {
+		os.RemoveAll(dir)
+		return nil, code, err
+	}
+
+	// remaining is how many more bytes of form fields may be read.
+	remaining := u.maxFieldsSize()
+	fields := make(url.Values)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(http.StatusBadRequest, fmt.Errorf("malformed multipart body: %v", err))
+		}
+
+		if part.FileName() == "" {
+			data, err := ioutil.ReadAll(io.LimitReader(part, remaining+1))
+			if err != nil {
+				return fail(http.StatusBadRequest, fmt.Errorf("error reading multipart body: %v", err))
+			}
+			if remaining -= int64(len(data)); remaining < 0 {
+				return fail(http.StatusRequestEntityTooLarge, fmt.Errorf("form fields larger than %d bytes", u.maxFieldsSize()))
+			}
+			fields.Add(part.FormName(), string(data))
+			continue
+		}
+
+		if u.MaxFiles > 0 && len(up.files) == u.MaxFiles {
+			return fail(http.StatusBadRequest, fmt.Errorf("more than %d files", u.MaxFiles))
+		}
+		file, code, err := u.spoolFile(dir, len(up.files), part.FileName(), part)
+		if err != nil {
+			return fail(code, err)
+		}
+		file.Field = part.FormName()
+		file.ContentType = part.Header.Get("Content-Type")
+		up.files = append(up.files, *file)
+	}
+
+	if f == nil {
+		f = &FormEnv{}
+	}
+	fieldsEnv, err := f.vars("FORM_", fields)
+	if err != nil {
+		return fail(http.StatusBadRequest, err)
+	}
+	up.env = append(fieldsEnv, "UPLOAD_DIR="+dir, fmt.Sprintf("UPLOAD_COUNT=%d", len(up.files)))
+	for i, file := range up.files {
+		up.env = append(up.env,
+			fmt.Sprintf("UPLOAD_%d_FIELD=%s", i, file.Field),
+			fmt.Sprintf("UPLOAD_%d_FILENAME=%s", i, file.Filename),
+			fmt.Sprintf("UPLOAD_%d_PATH=%s", i, file.Path),
+			fmt.Sprintf("UPLOAD_%d_SIZE=%d", i, file.Size),
+			fmt.Sprintf("UPLOAD_%d_TYPE=%s", i, file.ContentType),
+		)
+	}
+	for _, e := range up.env {
+		if strings.IndexByte(e, 0) != -1 {
+			return fail(http.StatusBadRequest, fmt.Errorf("multipart body contains a NUL byte in %s", e[:strings.IndexByte(e, '=')]))
+		}
+	}
+
+	if u.Manifest {
+		manifest := struct {
+			Files  []uploadedFile `json:"files"`
+			Fields url.Values     `json:"fields"`
+		}{up.files, fields}
+		if manifest.Files == nil {
+			manifest.Files = []uploadedFile{}
+		}
+		data, _ := json.Marshal(manifest)
+		name := filepath.Join(dir, "manifest.json")
+		if err := ioutil.WriteFile(name, data, 0600); err != nil {
+			return fail(http.StatusInternalServerError, err)
+		}
+		up.env = append(up.env, "UPLOAD_MANIFEST="+name)
+	}
+
+	return up, 0, nil
+}
+
+// spoolFile writes the ith uploaded file, named filename by the HTTP client, to dir.
+// The spooled file is named after its index rather than filename, only keeping filename's extension if it's a sensible one.
+func (u *Uploads) spoolFile(dir string, i int, filename string, r io.Reader) (*uploadedFile, int, error) {
+	ext := filepath.Ext(filename)
+	if !uploadExtRegex.MatchString(ext) {
+		ext = ""
+	}
+	name := filepath.Join(dir, fmt.Sprintf("upload-%d%s", i, ext))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	defer f.Close()
+
+	if u.MaxFileSize > 0 {
+		r = io.LimitReader(r, u.MaxFileSize+1)
+	}
+	n, err := io.Copy(f, r)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("error reading uploaded file: %v", err)
+	}
+	if u.MaxFileSize > 0 && n > u.MaxFileSize {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("uploaded file larger than %d bytes", u.MaxFileSize)
+	}
+	return &uploadedFile{Filename: filename, Path: name, Size: n}, 0, nil
+}
+
+func (u *Uploads) maxFieldsSize() int64 {
+	if u.MaxFieldsSize <= 0 {
+		return DefaultMaxFormSize
+	}
+	return u.MaxFieldsSize
+}
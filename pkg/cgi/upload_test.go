@@ -0,0 +1,153 @@
+package cgi
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestUploads(t *testing.T) {
+	type file struct {
+		Field, Name, Content string
+	}
+	body := func(files []file, fields map[string]string) (*bytes.Buffer, string) {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		for k, v := range fields {
+			mw.WriteField(k, v)
+		}
+		for _, f := range files {
+			fw, _ := mw.CreateFormFile(f.Field, f.Name)
+			fw.Write([]byte(f.Content))
+		}
+		mw.Close()
+		return &buf, mw.FormDataContentType()
+	}
+
+	type test struct {
+		Name           string
+		Uploads        *Uploads
+		Files          []file
+		Fields         map[string]string
+		ExpectedStatus int
+		ExpectedBody   string
+	}
+
+	tt := []test{
+		test{
+			Name:           "File and field",
+			Uploads:        &Uploads{},
+			Files:          []file{{"report", "../../etc/q1.csv", "a,b\n1,2"}},
+			Fields:         map[string]string{"note": "hello"},
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   "1|report|q1.csv|7|application/octet-stream|a,b\n1,2|hello|\n",
+		},
+		test{
+			Name:           "No files",
+			Uploads:        &Uploads{},
+			Fields:         map[string]string{"note": "hello"},
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   "0||||||hello|\n",
+		},
+		test{
+			Name:           "File too large",
+			Uploads:        &Uploads{MaxFileSize: 4},
+			Files:          []file{{"report", "q1.csv", "a,b\n1,2"}},
+			ExpectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		test{
+			Name:           "Too many files",
+			Uploads:        &Uploads{MaxFiles: 1},
+			Files:          []file{{"a", "a.txt", "a"}, {"b", "b.txt", "b"}},
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		test{
+			Name:           "Fields too large",
+			Uploads:        &Uploads{MaxFieldsSize: 4},
+			Fields:         map[string]string{"note": "hello"},
+			ExpectedStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tc := range tt {
+		h, err := New("./uploads.sh",
+			WithDir("."),
+			WithOutputHandler(EZOutputHandler),
+			WithUploads(tc.Uploads),
+		)
+		if err != nil {
+			t.Fatalf("%s: error while creating handler: %s", tc.Name, err)
+		}
+
+		b, ctype := body(tc.Files, tc.Fields)
+		r := httptest.NewRequest("POST", "/", b)
+		r.Header.Set("Content-Type", ctype)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != tc.ExpectedStatus {
+			t.Fatalf("%s: wrong status - expected: %d\treceived: %d", tc.Name, tc.ExpectedStatus, w.Code)
+		}
+		if tc.ExpectedBody == "" {
+			continue
+		}
+		lines := strings.SplitN(w.Body.String(), "\n", 2)
+		if len(lines) < 2 || lines[1] != tc.ExpectedBody {
+			t.Fatalf("%s: wrong body - expected: %q\treceived: %q", tc.Name, tc.ExpectedBody, w.Body.String())
+		}
+		if _, err := os.Stat(lines[0]); !os.IsNotExist(err) {
+			t.Fatalf("%s: upload directory %q wasn't removed", tc.Name, lines[0])
+		}
+	}
+}
+
+func TestUploadsManifest(t *testing.T) {
+	h, err := New("./uploads.sh",
+		WithDir("."),
+		WithOutputHandler(EZOutputHandler),
+		WithUploads(&Uploads{Manifest: true}),
+	)
+	if err != nil {
+		t.Fatalf("error while creating handler: %s", err)
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("note", "hello")
+	fw, _ := mw.CreateFormFile("report", "q1.csv")
+	fw.Write([]byte("a,b"))
+	mw.Close()
+	r := httptest.NewRequest("POST", "/", &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	lines := strings.SplitN(w.Body.String(), "\n", 3)
+	if len(lines) < 3 {
+		t.Fatalf("missing manifest: %q", w.Body.String())
+	}
+	var manifest struct {
+		Files []struct {
+			Field    string `json:"field"`
+			Filename string `json:"filename"`
+			Path     string `json:"path"`
+			Size     int64  `json:"size"`
+		} `json:"files"`
+		Fields map[string][]string `json:"fields"`
+	}
+	if err := json.Unmarshal([]byte(lines[2]), &manifest); err != nil {
+		t.Fatalf("invalid manifest: %s: %q", err, lines[2])
+	}
+	if len(manifest.Files) != 1 || manifest.Files[0].Field != "report" || manifest.Files[0].Filename != "q1.csv" ||
+		manifest.Files[0].Size != 3 || !strings.HasPrefix(manifest.Files[0].Path, lines[0]) {
+		t.Fatalf("wrong files in manifest: %+v", manifest.Files)
+	}
+	if len(manifest.Fields["note"]) != 1 || manifest.Fields["note"][0] != "hello" {
+		t.Fatalf("wrong fields in manifest: %v", manifest.Fields)
+	}
+}
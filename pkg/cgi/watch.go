@@ -0,0 +1,106 @@
+package cgi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// watchInterval is how often a watchedFile checks whether its file has changed.
+const watchInterval = time.Second
+
+// logFunc logs a message, such as Handler.logErr or log.Printf.
+type logFunc func(format string, v ...interface{})
+
+// watchedFile keeps the parsed contents of a file up to date, reloading the file whenever its modification time or size changes.
+// If a reload fails the previously loaded contents are kept, each version of the file that fails to load is only logged about once.
+type watchedFile struct {
+	path string
+	// parse parses the contents of the file, returning warnings about anything it skipped along with the parsed value.
+	parse func(data []byte) (interface{}, []string, error)
+
+	mu      sync.Mutex
+	value   interface{}
+	modTime time.Time
+	size    int64
+	checked time.Time
+	// statFailed is whether the last check of the file failed, so that a missing file is only logged about once.
+	statFailed bool
+	// pending holds the messages not logged yet, such as the warnings of the initial load.
+	pending []string
+}
+
+// newWatchedFile loads the file at path, it fails if the file can't be read or parsed.
+func newWatchedFile(path string, parse func(data []byte) (interface{}, []string, error)) (*watchedFile, error) {
+	f := &watchedFile{path: path, parse: parse}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.load(fi); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// load loads the file described by fi.
+// The modification time and size of fi are recorded even if it fails, so that the same version isn't loaded again.
+func (f *watchedFile) load(fi os.FileInfo) error {
+	f.modTime = fi.ModTime()
+	f.size = fi.Size()
+	f.checked = time.Now()
+
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	value, warnings, err := f.parse(data)
+	if err != nil {
+		return err
+	}
+	f.value = value
+	for _, w := range warnings {
+		f.pending = append(f.pending, fmt.Sprintf("cgi: %s: %s", f.path, w))
+	}
+	return nil
+}
+
+// get returns the parsed contents of the file, reloading it first if it has changed.
+// Problems with the file are logged with logf.
+func (f *watchedFile) get(logf logFunc) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	defer f.flush(logf)
+
+	if time.Since(f.checked) < watchInterval {
+		return f.value
+	}
+	f.checked = time.Now()
+
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		if !f.statFailed {
+			f.pending = append(f.pending, fmt.Sprintf("cgi: error checking %s, keeping previous version: %v", f.path, err))
+		}
+		f.statFailed = true
+		return f.value
+	}
+	f.statFailed = false
+	if fi.ModTime().Equal(f.modTime) && fi.Size() == f.size {
+		return f.value
+	}
+	if err := f.load(fi); err != nil {
+		f.pending = append(f.pending, fmt.Sprintf("cgi: error reloading %s, keeping previous version: %v", f.path, err))
+	}
+	return f.value
+}
+
+// flush logs the pending messages with logf.
+func (f *watchedFile) flush(logf logFunc) {
+	for _, msg := range f.pending {
+		logf("%s", msg)
+	}
+	f.pending = nil
+}
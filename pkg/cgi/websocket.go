@@ -0,0 +1,390 @@
+package cgi
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// DefaultWebSocketMaxMessageSize is the default maximum size in bytes of a message sent by a WebSocket client.
+const DefaultWebSocketMaxMessageSize = 1 << 20
+
+// WebSocket opcodes and close codes as defined in RFC 6455 Sections 5.2 and 7.4.1.
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+
+	wsCloseNormal        = 1000
+	wsCloseProtocolError = 1002
+	wsCloseTooBig        = 1009
+	wsCloseInternalError = 1011
+)
+
+const wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	errWSProtocol = errors.New("websocket protocol error")
+	errWSTooBig   = errors.New("websocket message too big")
+	errWSClosed   = errors.New("websocket connection closed")
+)
+
+// WebSocketHandler bridges WebSocket connections to the executable of Handler, websocketd style.
+// Each connection starts one long-lived process which is given the same environment Handler.ServeHTTP would give it.
+// Each message received from the WebSocket client is written to the process' stdin as a line,
+// each line the process writes to its stdout is sent to the WebSocket client as a message.
+//
+// When the process exits the connection is closed with status 1000 if the process exited successfully and status 1011 otherwise.
+// When the WebSocket client closes the connection the process is killed.
+type WebSocketHandler struct {
+	Handler *Handler
+
+	// AllowedOrigins lists the values of the Origin header that are allowed to connect, "*" allows any origin.
+	// If empty, only clients whose Origin matches the requests Host are allowed to connect.
+	// Requests without an Origin header are always allowed since they don't come from a browser.
+	AllowedOrigins []string
+	// MaxMessageSize is the maximum size in bytes of a message sent by the WebSocket client.
+	// Defaults to DefaultWebSocketMaxMessageSize.
+	MaxMessageSize int64
+}
+
+func (wh *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h := wh.Handler.config()
+
+	if !h.allowIP(w, r) {
+		return
+	}
+	r, ok := h.admit(w, r)
+	if !ok {
+		return
+	}
+
+	if !isWebSocketUpgrade(r) {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Expected a WebSocket handshake.", http.StatusBadRequest)
+		return
+	}
+	if !wh.checkOrigin(r) {
+		http.Error(w, "Origin not allowed.", http.StatusForbidden)
+		h.logErr("cgi: websocket origin not allowed: %s", r.Header.Get("Origin"))
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		h.logErr("cgi: response writer does not support hijacking")
+		return
+	}
+
+	internalError := func(err error) {
+		w.WriteHeader(http.StatusInternalServerError)
+		h.logErr("CGI error: %v", err)
+	}
+
+	env, ok := h.requestEnv(w, r)
+	if !ok {
+		return
+	}
+	if h.Args, ok = h.requestArgs(w, r); !ok {
+		return
+	}
+
+	// Stdin is piped manually so that waiting on the process doesn't wait on the WebSocket client.
+	stdinRead, stdinWrite, err := os.Pipe()
+	if err != nil {
+		internalError(err)
+		return
+	}
+	x, err := h.start(env, stdinRead)
+	stdinRead.Close()
+	if err != nil {
+		stdinWrite.Close()
+		internalError(err)
+		return
+	}
+	defer x.close()
+
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		stdinWrite.Close()
+		internalError(err)
+		return
+	}
+	defer conn.Close()
+
+	accept := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + wsAcceptGUID))
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(accept[:]))
+	if err := brw.Flush(); err != nil {
+		stdinWrite.Close()
+		h.logErr("cgi: websocket handshake error: %v", err)
+		return
+	}
+
+	maxSize := wh.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = DefaultWebSocketMaxMessageSize
+	}
+	ws := &wsConn{conn: conn, brw: brw}
+
+	// Client messages are read in their own goroutine, once the client goes away the process is killed.
+	go func() {
+		defer x.Kill()
+		defer stdinWrite.Close()
+		for {
+			msg, err := ws.readMessage(maxSize)
+			if err == nil {
+				msg = append(msg, '\n')
+				if _, err := stdinWrite.Write(msg); err != nil {
+					h.logErr("cgi: websocket stdin error: %v", err)
+					ws.close(wsCloseInternalError, "")
+					return
+				}
+				continue
+			}
+			switch err {
+			case io.EOF:
+				ws.close(wsCloseNormal, "")
+			case errWSTooBig:
+				ws.close(wsCloseTooBig, "")
+			case errWSProtocol:
+				ws.close(wsCloseProtocolError, "")
+			}
+			return
+		}
+	}()
+
+	linebody := bufio.NewReaderSize(x.Stdout, 1024)
+	for {
+		line, err := linebody.ReadString('\n')
+		if line != "" {
+			line = strings.TrimRight(line, "\r\n")
+			opcode := byte(wsOpText)
+			if !utf8.ValidString(line) {
+				opcode = wsOpBinary
+			}
+			if werr := ws.writeFrame(opcode, []byte(line)); werr != nil {
+				// The client is gone, nothing reads the process' stdout anymore and reading from the
+				// connection may never fail if it's half-open, so neither would ever finish on their own.
+				x.Kill()
+				conn.Close()
+				x.Wait()
+				return
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	if _, err := x.Wait(); err != nil {
+		ws.close(wsCloseInternalError, err.Error())
+		return
+	}
+	ws.close(wsCloseNormal, "")
+}
+
+func (wh *WebSocketHandler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(wh.AllowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	for _, o := range wh.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		headerHasToken(r.Header, "Connection", "upgrade") &&
+		headerHasToken(r.Header, "Upgrade", "websocket") &&
+		r.Header.Get("Sec-WebSocket-Version") == "13" &&
+		r.Header.Get("Sec-WebSocket-Key") != ""
+}
+
+func headerHasToken(header http.Header, key, token string) bool {
+	for _, v := range header[key] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn is the server side of a WebSocket connection.
+// Reads must all happen on the same goroutine, writes may happen concurrently.
+type wsConn struct {
+	conn net.Conn
+	brw  *bufio.ReadWriter
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// readMessage returns the payload of the next data message sent by the client.
+// Control frames are handled transparently.
+// Returns io.EOF once the client has sent a close frame.
+func (c *wsConn) readMessage(maxSize int64) ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		fin, opcode, payload, err := c.readFrame(maxSize)
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsOpClose:
+			return nil, io.EOF
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpText, wsOpBinary:
+			if started {
+				return nil, errWSProtocol
+			}
+			started = true
+		case wsOpContinuation:
+			if !started {
+				return nil, errWSProtocol
+			}
+		default:
+			return nil, errWSProtocol
+		}
+		if int64(len(msg)+len(payload)) > maxSize {
+			return nil, errWSTooBig
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame(maxSize int64) (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.brw, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	length := int64(head[1] & 0x7F)
+
+	// Clients must always mask their frames and control frames can't be fragmented.
+	if !masked || head[0]&0x70 != 0 || (opcode >= wsOpClose && (!fin || length > 125)) {
+		err = errWSProtocol
+		return
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.brw, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.brw, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if length < 0 || length > maxSize {
+		err = errWSTooBig
+		return
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.brw, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.brw, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errWSClosed
+	}
+	return c.writeFrameLocked(opcode, payload)
+}
+
+func (c *wsConn) writeFrameLocked(opcode byte, payload []byte) error {
+	head := []byte{0x80 | opcode, 0}
+	switch length := len(payload); {
+	case length <= 125:
+		head[1] = byte(length)
+	case length <= 0xFFFF:
+		head[1] = 126
+		head = append(head, 0, 0)
+		binary.BigEndian.PutUint16(head[2:], uint16(length))
+	default:
+		head[1] = 127
+		head = append(head, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(head[2:], uint64(length))
+	}
+	if _, err := c.brw.Write(head); err != nil {
+		return err
+	}
+	if _, err := c.brw.Write(payload); err != nil {
+		return err
+	}
+	return c.brw.Flush()
+}
+
+// close sends a close frame with the given status code and reason, only the first call has any effect.
+func (c *wsConn) close(code uint16, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+
+	// Control frame payloads are limited to 125 bytes, 2 of which are taken by the status code.
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, code)
+	payload = append(payload, reason...)
+	c.writeFrameLocked(wsOpClose, payload)
+}
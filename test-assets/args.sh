@@ -0,0 +1,6 @@
+#!/bin/bash
+
+for a in "$@"; do
+	echo "[$a]"
+done
+echo "$(cat)"
@@ -0,0 +1,7 @@
+#!/bin/bash
+
+echo "Access-Control-Allow-Origin: *"
+echo "Vary: Accept"
+echo "Content-Type: text/plain"
+echo
+echo "PASS"
@@ -0,0 +1,4 @@
+#!/bin/bash
+
+read -r line
+echo "$line"
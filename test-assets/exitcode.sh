@@ -0,0 +1,5 @@
+#!/bin/bash
+
+echo "PASS"
+echo "STDERR" >&2
+exit 3
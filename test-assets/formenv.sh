@@ -0,0 +1,3 @@
+#!/bin/bash
+
+echo "$QUERY_STRING|$QUERY_USER_ID|$QUERY_TAG|$FORM_NAME|$(cat)"
@@ -0,0 +1,3 @@
+#!/bin/bash
+
+echo '{"headers": {"Content-Type": "text/html", "Test-Header": ["PASS"]}, "body": "PASS\nPASS\n\nPASS\n"}'
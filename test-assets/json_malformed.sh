@@ -0,0 +1,3 @@
+#!/bin/bash
+
+echo '{"status": 200, "body": "PASS"'
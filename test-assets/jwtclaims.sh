@@ -0,0 +1,3 @@
+#!/bin/bash
+
+echo "$AUTH_TYPE $REMOTE_USER $JWT_CLAIM_ROLE $JWT_CLAIM_GROUPS$HTTP_AUTHORIZATION"
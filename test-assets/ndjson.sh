@@ -0,0 +1,5 @@
+#!/bin/bash
+
+echo '{"headers": {"Content-Type": "text/html", "Test-Header": "PASS"}, "body": "PASS\n"}'
+echo '{"body": "PASS\n\n"}'
+echo '{"body_base64": "UEFTUwo="}'
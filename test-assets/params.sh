@@ -0,0 +1,3 @@
+#!/bin/bash
+
+echo "$SCRIPT_NAME $PATH_INFO $CGI_PARAM_ID $CGI_PARAM_REPORT_NAME $*"
@@ -0,0 +1,8 @@
+#!/bin/bash
+
+echo "Content-Type: text/html"
+if [ -n "$QUERY_STRING" ]; then
+	echo "Test-Header: $QUERY_STRING"
+fi
+echo ""
+echo "PASS"
@@ -0,0 +1,3 @@
+#!/bin/bash
+
+echo "$AUTH_TYPE $REMOTE_USER$HTTP_AUTHORIZATION"
@@ -0,0 +1,4 @@
+#!/bin/bash
+
+echo "START"
+exec sleep 5
@@ -0,0 +1,6 @@
+#!/bin/bash
+
+echo "event: greeting"
+echo "id: 1"
+echo "hello"
+echo "world"
@@ -0,0 +1,6 @@
+#!/bin/bash
+
+while true; do
+	echo "tick"
+	sleep 0.1
+done
@@ -0,0 +1,5 @@
+#!/bin/bash
+
+echo "hello"
+sleep 0.3
+echo "world"
@@ -0,0 +1,7 @@
+#!/bin/bash
+
+echo "$HTTPS $SSL_PROTOCOL $SSL_CIPHER $SSL_CLIENT_VERIFY"
+echo "$SSL_CLIENT_S_DN"
+echo "$SSL_CLIENT_I_DN"
+echo "$SSL_CLIENT_SERIAL"
+echo "$SSL_CLIENT_CERT"
@@ -0,0 +1,7 @@
+#!/bin/bash
+
+echo "Content-Type: text/plain"
+echo "Trailer: X-Rows"
+echo ""
+echo "PASS"
+echo "X-Rows: 1" >&$CGI_TRAILER_FD
@@ -0,0 +1,9 @@
+#!/bin/bash
+
+echo "Content-Type: text/plain"
+echo "Trailer: X-Rows"
+echo ""
+echo "PASS"
+echo "X-Rows: 1" >&$CGI_TRAILER_FD
+# The background process inherits the trailer file descriptor and stderr, but not stdout.
+sleep 5 >/dev/null &
@@ -0,0 +1,7 @@
+#!/bin/bash
+
+echo "$UPLOAD_DIR"
+echo "$UPLOAD_COUNT|$UPLOAD_0_FIELD|$UPLOAD_0_FILENAME|$UPLOAD_0_SIZE|$UPLOAD_0_TYPE|$([ -n "$UPLOAD_0_PATH" ] && cat "$UPLOAD_0_PATH")|$FORM_NOTE|$(cat)"
+if [ -n "$UPLOAD_MANIFEST" ]; then
+	cat "$UPLOAD_MANIFEST"
+fi